@@ -40,7 +40,15 @@ The file type reads input from a file. If multipart is set to false each line
 is read as a separate message. If multipart is set to true each line is read as
 a message part, and an empty line indicates the end of a message.
 
-If the delimiter field is left empty then line feed (\n) is used.`,
+A multipart message that is cut short by the end of the input is given the
+metadata field ` + "`multipart_incomplete`" + ` with the value ` + "`true`" + `.
+
+If the delimiter field is left empty then line feed (\n) is used.
+
+Each message part is given a ` + "`line_number`" + ` metadata field containing the
+position of its line within the file, starting at 1, and the metadata fields
+` + "`start_offset` and `end_offset`" + ` containing the byte range of the line,
+including its delimiter, within the file.`,
 	}
 }
 
@@ -92,6 +100,7 @@ func NewFile(conf Config, mgr types.Manager, log log.Modular, stats metrics.Type
 		reader.OptLinesSetDelimiter(delim),
 		reader.OptLinesSetMaxBuffer(conf.File.MaxBuffer),
 		reader.OptLinesSetMultipart(conf.File.Multipart),
+		reader.OptLinesSetStats(stats),
 	)
 	if err != nil {
 		return nil, err
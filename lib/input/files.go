@@ -21,10 +21,14 @@
 package input
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/Jeffail/benthos/v3/lib/input/reader"
 	"github.com/Jeffail/benthos/v3/lib/log"
 	"github.com/Jeffail/benthos/v3/lib/metrics"
 	"github.com/Jeffail/benthos/v3/lib/types"
+	"github.com/cenkalti/backoff"
 )
 
 //------------------------------------------------------------------------------
@@ -38,14 +42,247 @@ message payload. The path can either point to a single file (resulting in only a
 single message) or a directory, in which case the directory will be walked and
 each file found will become a message.
 
+Files can instead be read from multiple paths by listing them in the field
+` + "`roots`" + `, in which case ` + "`path`" + ` must be left empty. Each root is
+searched in the order listed, and each message is given the metadata field
+` + "`path_index`" + ` containing the index of the root that the file was found
+within.
+
+Alternatively, a list of files can be consumed without walking any directories
+by listing them in the field ` + "`paths`" + `, in which case ` + "`path`" + ` and
+` + "`roots`" + ` must be left empty. The files are consumed in the order listed and,
+as with a manifest, the fields ` + "`include`" + `, ` + "`exclude`" + ` and ` + "`sort`" + ` do
+not apply. A listed file that cannot be read is handled according to
+` + "`on_error`" + `.
+
+When ` + "`expand_env`" + ` is set to true references to environment variables
+within ` + "`path`" + `, ` + "`roots`" + ` and ` + "`paths`" + `, such as
+` + "`/data/${TENANT}`" + `, are expanded before searching for files. A variable
+that is unset or empty results in an error rather than a path that was not
+intended.
+
+The fields ` + "`include`" + ` and ` + "`exclude`" + ` can be used to filter the
+files found within a directory by glob patterns, which are matched against the
+path of each file relative to the configured path or root. A pattern segment of
+` + "`**`" + ` matches any number of directories, e.g. ` + "`**/*.log`" + `. An empty
+` + "`include`" + ` matches all files.
+
+Special files such as named pipes and devices are ignored by default. The field
+` + "`special_files`" + ` can be set to ` + "`error`" + ` in order to treat them as files that
+cannot be read, or ` + "`read`" + ` in order to consume them, in which case a read that
+stalls for longer than ` + "`special_files_timeout`" + ` fails the file.
+
+Symlinks found whilst walking a directory are ignored by default, which
+prevents files outside of the configured path from being consumed. The field
+` + "`symlinks`" + ` can be set to ` + "`follow`" + ` in order to consume symlinked files and
+walk symlinked directories, where a directory that has already been walked is
+not walked again, or ` + "`error`" + ` in order to fail when a symlink is found. The
+configured path itself is always followed, and this field does not apply to a
+manifest.
+
+When ` + "`skip_empty`" + ` is set to true files that are empty are ignored, which
+when combined with ` + "`watch`" + ` prevents a file from being consumed before its
+writer has added any content.
+
+When ` + "`skip_hidden`" + ` is set to true files and directories found whilst
+walking that have a name beginning with a dot are ignored, along with the
+contents of such directories. The configured path itself is never ignored.
+
+Directories are walked recursively by default, set ` + "`recursive`" + ` to false in
+order to only read files that are directly within the configured directory.
+
+By default files are consumed in the order that they are walked. The field
+` + "`sort`" + ` can be set to ` + "`name`" + `, ` + "`mod_time`" + ` or ` + "`size`" + ` in order to
+consume files in a deterministic ascending order of the given key instead.
+
+When ` + "`delete_on_finish`" + ` is set to true each file is deleted once its
+message has been successfully delivered. Files are left in place when delivery
+fails.
+
+The field ` + "`codec`" + ` can be set to ` + "`gzip`" + `, ` + "`bzip2`" + ` or ` + "`zstd`" + ` in
+order to decompress the contents of each file. When set to ` + "`auto`" + ` the
+codec of each file is chosen by its extension (` + "`.gz`" + `, ` + "`.bz2`" + `, ` + "`.zst`" + `
+or ` + "`.zstd`" + `), falling back to the magic bytes at the start of the file, and
+files that are not recognised are read raw. When a codec is set each message is
+given the metadata field ` + "`codec`" + ` naming the codec that was applied, which
+is ` + "`none`" + ` for files that were read raw. A file that fails to decompress
+results in an error for that file only and the remaining files are still
+consumed.
+
+The field ` + "`on_error`" + ` determines what happens when a file cannot be opened
+or read. The default, ` + "`abort`" + `, reports the error, and a directory that
+cannot be walked prevents the input from starting. When set to ` + "`skip`" + ` the
+file is logged and skipped, and the metric ` + "`files.errors`" + ` is incremented.
+
+By default a message that fails to be delivered is resent until it succeeds,
+which blocks the input on a file that can never be delivered. Setting
+` + "`retry_failed`" + ` to false instead drops messages that fail to be delivered
+and moves on, and the files they came from are not deleted when
+` + "`delete_on_finish`" + ` is set. This is intended for batch jobs that should
+fail fast rather than retry indefinitely. Failed messages are lost, and a
+` + "`checkpoint`" + ` still moves past their files once a later file is delivered,
+so the files left in place are the only record of them.
+
+When ` + "`checkpoint`" + ` is set to a file path the path of the last file to be
+successfully delivered is written to it, and when the input is restarted all
+files up to and including that file, in the order that files are read, are
+skipped. Nothing is skipped when the file is no longer found, and so the order
+that files are read in must not change between restarts, such as with
+` + "`sort`" + ` set to ` + "`name`" + `.
+
+When files are consumed whole they are read in chunks of ` + "`read_chunk_size`" + `
+bytes, and when ` + "`max_file_size`" + ` is greater than zero a file with contents
+that exceed that many bytes, after decompression, is not consumed and is
+instead handled according to ` + "`on_error`" + `. This prevents a very large file
+from being consumed as a single message by accident.
+
+When ` + "`head_bytes`" + ` is greater than zero only that many bytes, after
+decompression, are read from each file consumed whole, and the file is closed
+early unless a ` + "`hash`" + ` is configured, in which case the remainder is
+still read in order to calculate the digest. Messages of files that were larger
+than the limit have the metadata field ` + "`truncated`" + ` set to
+` + "`true`" + `. A file is then only rejected by ` + "`max_file_size`" + ` when that
+limit is no greater than ` + "`head_bytes`" + `. This field cannot be combined
+with ` + "`line_delimited`" + ` or ` + "`tail_lines`" + `.
+
+When files are consumed whole the field ` + "`hash`" + ` can be set to ` + "`md5`" + `,
+` + "`sha1`" + ` or ` + "`sha256`" + ` in order to add the hex encoded digest of the raw
+file contents as the metadata field ` + "`hash`" + `, along with the algorithm used
+as the metadata field ` + "`hash_algorithm`" + `.
+
+When ` + "`line_delimited`" + ` is set to true each file is streamed rather than read
+in full, and each line of a file is consumed as a message. Lines are split by
+the ` + "`delimiter`" + ` field, which defaults to line feed (\n) when left empty,
+and the field ` + "`max_buffer`" + ` sets the maximum length of a line. Messages in
+this mode also carry the metadata fields ` + "`line_number`" + `, ` + "`start_offset`" + `
+and ` + "`end_offset`" + `, where the offsets are the byte range of the line within
+the decompressed contents of the file.
+
+When files are consumed whole the field ` + "`skip_leading_lines`" + ` removes a
+number of lines from the start of each file, and when ` + "`header_metadata`" + ` is
+set to true the first remaining line is removed and stored in the metadata
+field ` + "`header`" + ` instead. Lines are split by the ` + "`delimiter`" + ` field.
+
+When ` + "`tail_lines`" + ` is set above zero only the last lines of each file, up
+to that number, are consumed. The start of these lines is found by reading
+backwards from the end of a file where possible, and files that are compressed
+or special are read in full instead. A delimiter at the end of a file does not
+count as an empty final line. This field cannot be combined with
+` + "`skip_leading_lines`" + ` or ` + "`header_metadata`" + `.
+
+The field ` + "`extension_delimiters`" + ` maps file extensions to the delimiter
+used for files with that extension in place of ` + "`delimiter`" + `, e.g. a map of
+` + "`dat: \"\\x1e\"`" + ` splits files ending in ` + "`.dat`" + ` by the record separator
+character. Extensions are matched regardless of case, and the extension of a
+compressed file is taken from its name without the extension of its codec,
+e.g. the ` + "`.gz`" + ` suffix.
+
+When ` + "`list_only`" + ` is set to true the contents of files are not read, and
+instead an empty message is emitted for each file found, carrying the metadata
+fields of the file. This is useful for taking an inventory of files cheaply,
+and fields that apply to the contents of files have no effect. This field
+cannot be combined with ` + "`line_delimited`" + `.
+
+Setting ` + "`max_concurrency`" + ` above one reads up to that many files at
+the same time, which can make better use of fast storage when there are many
+small files. Messages are emitted in the order that reads complete, and so the
+ordering of files (including any ` + "`sort`" + `) is best-effort only. Files
+are still deleted and checkpointed only once acknowledged, and the checkpoint
+never moves past a file that has yet to be acknowledged. This field cannot be
+combined with ` + "`line_delimited`" + ` or ` + "`group_by_dir`" + `.
+
+The field ` + "`archive`" + ` can be set to ` + "`tar`" + ` or ` + "`zip`" + ` in order to
+open files as archives, where each regular file within an archive is emitted
+as a message of its own. Directory entries are skipped. When set to
+` + "`auto`" + ` only files ending in ` + "`.tar`" + `, ` + "`.tar.gz`" + `, ` + "`.tgz`" + ` or
+` + "`.zip`" + ` are opened as archives, and other files are read as normal.
+Tarballs are decompressed when gzipped. Messages of entries carry the path of
+the archive along with the metadata fields ` + "`entry_name`" + ` and
+` + "`entry_size`" + `, and an archive is only deleted or checkpointed once all of
+its entries have been acknowledged. An archive that cannot be read is handled
+according to ` + "`on_error`" + `. This field cannot be combined with
+` + "`line_delimited`" + `, ` + "`group_by_dir`" + `, ` + "`list_only`" + `, ` + "`tail_lines`" + ` or
+` + "`max_concurrency`" + `.
+
+When ` + "`from_manifest`" + ` is set to true the path instead points to a manifest
+file, where each line is the path of a file to consume, and files are consumed
+in the order that they are listed. The fields ` + "`include`" + `, ` + "`exclude`" + ` and
+` + "`sort`" + ` do not apply to a manifest. A listed file that cannot be read results
+in an empty message with the metadata field ` + "`read_error`" + ` describing the
+error.
+
+The fields ` + "`newer_than`" + ` and ` + "`older_than`" + ` restrict the files consumed to
+those with a modification time within a window. Each can be either a duration,
+which is relative to the time that the path is walked (e.g. ` + "`24h`" + `), or an
+absolute RFC3339 timestamp. When watching, relative windows move forward with
+each poll. These fields do not apply to a manifest.
+
+When ` + "`startup_timeout`" + ` is set to a duration the input retries with an
+exponential backoff when the configured path cannot be found at startup, until
+the duration has elapsed. This is useful when the path is mounted shortly after
+Benthos starts. By default the input fails immediately.
+
+When ` + "`watch`" + ` is set to true the input does not close once all files have
+been consumed, and instead checks the path for new files at the interval set by
+` + "`poll_interval`" + `. A file is consumed again if its modification time
+changes. When ` + "`max_poll_interval`" + ` is set the interval doubles after each
+poll that finds no files, up to that maximum, and returns to
+` + "`poll_interval`" + ` once files are found. This reduces the overhead of
+watching a path where files arrive rarely.
+
+A ` + "`stability_window`" + ` duration, e.g. ` + "`5s`" + `, can be set in order to avoid
+reading files that are still being written. Files are only read once their size
+and modification time have remained unchanged for the window after being found.
+When watching, files that are still changing are left to be found again by a
+later poll, otherwise they are checked again after a further window.
+
+When ` + "`group_by_dir`" + ` is set to true all files within the same directory
+are read as a single multiple part message, with each file being a part. Each
+part is given the metadata field ` + "`dir`" + `, containing the directory of the
+message. This field cannot be combined with ` + "`line_delimited`" + `.
+
+When ` + "`emit_eod`" + ` is set to true an empty message is emitted once the last
+file found within a directory has been consumed, with the metadata field
+` + "`eod`" + ` set to ` + "`true`" + ` and the field ` + "`dir`" + ` containing the directory.
+Only files directly within a directory count towards it, and when watching a
+directory may be marked again once further files within it are consumed.
+
 ### Metadata
 
 This input adds the following metadata fields to each message:
 
 ` + "``` text" + `
 - path
+- size_bytes
+- mod_time_unix
+- mod_time
 ` + "```" + `
 
+Files found by walking are also given the field ` + "`depth`" + `, the number of
+directories between a file and the configured path or root it was found within,
+where a file directly within it has a depth of 0. Files listed in a manifest or
+in ` + "`paths`" + ` are not given a depth.
+
+When ` + "`detect_content_type`" + ` is set to true the content type of each file
+is detected from the first 512 bytes of its decoded contents and added as the
+field ` + "`content_type`" + `, e.g. ` + "`text/plain; charset=utf-8`" + `. The detected
+bytes are still included in the message.
+
+When ` + "`count_files`" + ` is set to true each message is given the metadata fields
+` + "`file_index`" + `, the position of its file amongst all files found starting at
+1, and ` + "`file_total`" + `, the number of files found. Files skipped due to a
+checkpoint are not counted, and when watching the total increases as new files
+are found.
+
+When ` + "`relative_paths`" + ` is set to true the field ` + "`path`" + ` contains the path
+of a file relative to the configured path or root it was found within, and the
+absolute path of the file is added as the field ` + "`absolute_path`" + `. This has
+no effect on files listed in a manifest.
+
+The field ` + "`metadata_prefix`" + ` can be used in order to add a prefix to the
+keys of all metadata fields added by this input, e.g. a prefix of
+` + "`files_`" + ` results in the field ` + "`files_path`" + `.
+
 You can access these metadata fields using
 [function interpolation](../config_interpolation.md#metadata).`,
 	}
@@ -55,11 +292,50 @@ You can access these metadata fields using
 
 // NewFiles creates a new Files input type.
 func NewFiles(conf Config, mgr types.Manager, log log.Modular, stats metrics.Type) (Type, error) {
-	f, err := reader.NewFiles(conf.Files)
+	f, err := newFilesReader(conf.Files, log, stats)
 	if err != nil {
 		return nil, err
 	}
+	if !conf.Files.RetryFailed {
+		return NewReader("files", f, log, stats)
+	}
 	return NewReader("files", reader.NewPreserver(f), log, stats)
 }
 
+// newFilesReader creates a files reader. When a startup timeout is configured
+// a failed attempt is retried with an exponential backoff until the timeout has
+// elapsed, which allows the path to be missing for a short time at startup.
+// Invalid config fields are reported immediately as they're never resolved by
+// retrying.
+func newFilesReader(conf reader.FilesConfig, log log.Modular, stats metrics.Type) (reader.Type, error) {
+	if len(conf.StartupTimeout) == 0 {
+		return reader.NewFiles(conf, log, stats)
+	}
+	timeout, err := time.ParseDuration(conf.StartupTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse startup timeout: %v", err)
+	}
+	if err = conf.ValidateFields(); err != nil {
+		return nil, err
+	}
+
+	boff := backoff.NewExponentialBackOff()
+	boff.InitialInterval = time.Millisecond * 100
+	boff.MaxInterval = time.Second * 5
+	boff.MaxElapsedTime = timeout
+
+	var f reader.Type
+	err = backoff.RetryNotify(func() error {
+		var ferr error
+		f, ferr = reader.NewFiles(conf, log, stats)
+		return ferr
+	}, boff, func(err error, wait time.Duration) {
+		log.Warnf("Failed to create files reader, retrying in %v: %v\n", wait, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
 //------------------------------------------------------------------------------
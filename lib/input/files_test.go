@@ -0,0 +1,118 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+	"github.com/Jeffail/benthos/v3/lib/response"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+func TestFilesStartupTimeout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_files_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "mount")
+
+	conf := NewConfig()
+	conf.Files.Path = path
+	if _, err = NewFiles(conf, nil, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from missing path")
+	}
+
+	go func() {
+		<-time.After(time.Millisecond * 200)
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, "foo"), []byte("bar"), 0644); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conf.Files.StartupTimeout = "10s"
+	f, err := NewFiles(conf, nil, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		f.CloseAsync()
+		if err := f.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var ts types.Transaction
+	select {
+	case ts = <-f.TransactionChan():
+	case <-time.After(time.Second):
+		t.Fatal("Timed out")
+	}
+	if exp, act := "bar", string(ts.Payload.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	select {
+	case ts.ResponseChan <- response.NewAck():
+	case <-time.After(time.Second):
+		t.Error("Timed out")
+	}
+}
+
+func TestFilesStartupTimeoutElapsed(t *testing.T) {
+	conf := NewConfig()
+	conf.Files.Path = "/does/not/exist"
+	conf.Files.StartupTimeout = "200ms"
+	if _, err := NewFiles(conf, nil, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from missing path")
+	}
+
+	conf.Files.StartupTimeout = "nope"
+	if _, err := NewFiles(conf, nil, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad startup timeout")
+	}
+}
+
+func TestFilesStartupTimeoutBadConfig(t *testing.T) {
+	conf := NewConfig()
+	conf.Files.Path = "/does/not/exist"
+	conf.Files.Codec = "nope"
+	conf.Files.StartupTimeout = "10s"
+
+	// Invalid fields are not retried until the timeout elapses.
+	start := time.Now()
+	if _, err := NewFiles(conf, nil, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad codec")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Config error was retried for %v", elapsed)
+	}
+}
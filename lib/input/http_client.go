@@ -229,6 +229,7 @@ func NewHTTPClient(conf Config, mgr types.Manager, log log.Modular, stats metric
 		reader.OptLinesSetDelimiter(delim),
 		reader.OptLinesSetMaxBuffer(conf.HTTPClient.Stream.MaxBuffer),
 		reader.OptLinesSetMultipart(conf.HTTPClient.Stream.Multipart),
+		reader.OptLinesSetStats(stats),
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,176 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// BatchPolicy is a set of rules for accumulating message parts into batches,
+// and is implemented by *batch.Policy.
+type BatchPolicy interface {
+	// Add a message part to the batch, returns true if the batch is ready to
+	// be flushed.
+	Add(part types.Part) bool
+
+	// Flush returns the current batch and clears it, or returns nil if the
+	// batch is empty.
+	Flush() types.Message
+
+	// Count returns the number of message parts in the current batch.
+	Count() int
+
+	// UntilNext returns the time until the current batch should be flushed due
+	// to a period, or a negative duration if a period is not set.
+	UntilNext() time.Duration
+}
+
+// contextReader is implemented by readers that are able to abandon a read once
+// a context ends, such as Lines.
+type contextReader interface {
+	ReadWithContext(ctx context.Context) (types.Message, error)
+}
+
+// Batcher is a wrapper for reader.Type implementations that accumulates the
+// messages read from the wrapped reader into batches according to a batch
+// policy, returning each batch as a single multiple part message.
+// Acknowledgements of a batch are forwarded to the wrapped reader, which
+// therefore only sees a successful acknowledgement once the whole batch has
+// been propagated. Batcher implements reader.Type.
+type Batcher struct {
+	r         Type
+	ctxReader contextReader
+	policy    BatchPolicy
+	hasPeriod bool
+
+	// An error returned by the wrapped reader whilst a batch was being
+	// accumulated, which is returned once the batch has been read.
+	pendingErr error
+}
+
+// NewBatcher returns a new Batcher wrapper around a reader.Type. A batch policy
+// with a period requires a reader that implements ReadWithContext, such as
+// Lines, as a read that is blocked waiting for data must be abandoned in order
+// to return a partial batch once the period elapses.
+func NewBatcher(r Type, policy BatchPolicy) (*Batcher, error) {
+	b := &Batcher{
+		r:         r,
+		policy:    policy,
+		hasPeriod: policy.UntilNext() >= 0,
+	}
+	if b.hasPeriod {
+		var ok bool
+		if b.ctxReader, ok = r.(contextReader); !ok {
+			return nil, errors.New("a batch period requires a reader that implements ReadWithContext")
+		}
+	}
+	return b, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Connect attempts to establish a connection to the source, if unsuccessful
+// returns an error. If the attempt is successful (or not necessary) returns
+// nil.
+func (b *Batcher) Connect() error {
+	return b.r.Connect()
+}
+
+// Acknowledge forwards an acknowledgement of the batches read since the last
+// call to the wrapped reader.
+func (b *Batcher) Acknowledge(err error) error {
+	return b.r.Acknowledge(err)
+}
+
+// Read attempts to read a batch of messages from the wrapped reader, blocking
+// until the batch policy is triggered. If the wrapped reader returns
+// types.ErrNotConnected or types.ErrTypeClosed then any messages accumulated
+// so far are returned as a batch first. If a period is configured then a read
+// of the wrapped reader is abandoned once it elapses and the batch is also
+// returned.
+func (b *Batcher) Read() (types.Message, error) {
+	if b.pendingErr != nil {
+		err := b.pendingErr
+		b.pendingErr = nil
+		return nil, err
+	}
+	for {
+		msg, err := b.read()
+		if err != nil {
+			if b.policy.Count() == 0 {
+				return nil, err
+			}
+			switch err {
+			case types.ErrNotConnected, types.ErrTypeClosed:
+				b.pendingErr = err
+				return b.policy.Flush(), nil
+			case context.DeadlineExceeded:
+				return b.policy.Flush(), nil
+			case types.ErrTimeout:
+				if b.hasPeriod && b.policy.UntilNext() <= 0 {
+					return b.policy.Flush(), nil
+				}
+			}
+			return nil, err
+		}
+
+		triggered := false
+		msg.Iter(func(i int, p types.Part) error {
+			if b.policy.Add(p) {
+				triggered = true
+			}
+			return nil
+		})
+		if triggered {
+			return b.policy.Flush(), nil
+		}
+	}
+}
+
+// read reads from the wrapped reader until the period of a partial batch, if
+// any, elapses.
+func (b *Batcher) read() (types.Message, error) {
+	if !b.hasPeriod || b.policy.Count() == 0 {
+		return b.r.Read()
+	}
+	ctx, done := context.WithTimeout(context.Background(), b.policy.UntilNext())
+	defer done()
+	return b.ctxReader.ReadWithContext(ctx)
+}
+
+// CloseAsync triggers the asynchronous closing of the reader.
+func (b *Batcher) CloseAsync() {
+	b.r.CloseAsync()
+}
+
+// WaitForClose blocks until either the reader is finished closing or a timeout
+// occurs.
+func (b *Batcher) WaitForClose(tout time.Duration) error {
+	return b.r.WaitForClose(tout)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,316 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/message/batch"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+type scriptedRead struct {
+	content []string
+	err     error
+}
+
+type scriptedReader struct {
+	reads []scriptedRead
+	acks  []error
+}
+
+func (r *scriptedReader) Connect() error {
+	return nil
+}
+func (r *scriptedReader) Read() (types.Message, error) {
+	if len(r.reads) == 0 {
+		return nil, types.ErrTypeClosed
+	}
+	read := r.reads[0]
+	r.reads = r.reads[1:]
+	if read.err != nil {
+		return nil, read.err
+	}
+	msg := message.New(nil)
+	for _, c := range read.content {
+		msg.Append(message.NewPart([]byte(c)))
+	}
+	return msg, nil
+}
+func (r *scriptedReader) Acknowledge(err error) error {
+	r.acks = append(r.acks, err)
+	return nil
+}
+func (r *scriptedReader) CloseAsync() {}
+func (r *scriptedReader) WaitForClose(time.Duration) error {
+	return nil
+}
+
+// contextScriptedReader is a scriptedReader that accepts, and ignores, a read
+// context.
+type contextScriptedReader struct {
+	*scriptedReader
+}
+
+func (r contextScriptedReader) ReadWithContext(context.Context) (types.Message, error) {
+	return r.Read()
+}
+
+func newTestBatcher(t *testing.T, r Type, conf batch.PolicyConfig) *Batcher {
+	t.Helper()
+	policy, err := batch.NewPolicy(conf, nil, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBatcher(r, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readBatch(t *testing.T, b *Batcher) ([]string, error) {
+	t.Helper()
+	msg, err := b.Read()
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	msg.Iter(func(i int, p types.Part) error {
+		parts = append(parts, string(p.Get()))
+		return nil
+	})
+	return parts, nil
+}
+
+//------------------------------------------------------------------------------
+
+func TestBatcherCount(t *testing.T) {
+	r := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"foo"}},
+			{content: []string{"bar"}},
+			{content: []string{"baz", "qux"}},
+			{content: []string{"quz"}},
+			{err: types.ErrNotConnected},
+			{content: []string{"fub"}},
+		},
+	}
+
+	conf := batch.NewPolicyConfig()
+	conf.Count = 2
+	b := newTestBatcher(t, r, conf)
+
+	for _, exp := range [][]string{
+		{"foo", "bar"},
+		{"baz", "qux"},
+		{"quz"},
+	} {
+		act, err := readBatch(t, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong batch: %v != %v", act, exp)
+		}
+	}
+	if _, err := readBatch(t, b); err != types.ErrNotConnected {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrNotConnected)
+	}
+	if act, exp := r.acks, []error(nil); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Unexpected acks: %v", act)
+	}
+
+	act, err := readBatch(t, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"fub"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong batch: %v != %v", act, exp)
+	}
+	if _, err = readBatch(t, b); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestBatcherByteSize(t *testing.T) {
+	r := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"foo"}},
+			{content: []string{"bar"}},
+			{content: []string{"bazqux"}},
+		},
+	}
+
+	conf := batch.NewPolicyConfig()
+	conf.ByteSize = 5
+	b := newTestBatcher(t, r, conf)
+
+	for _, exp := range [][]string{{"foo", "bar"}, {"bazqux"}} {
+		act, err := readBatch(t, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong batch: %v != %v", act, exp)
+		}
+	}
+}
+
+func TestBatcherPeriod(t *testing.T) {
+	r := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"foo"}},
+			{err: types.ErrTimeout},
+			{err: types.ErrTimeout},
+			{content: []string{"bar"}},
+		},
+	}
+
+	conf := batch.NewPolicyConfig()
+	conf.Count = 10
+	conf.Period = "50ms"
+	b := newTestBatcher(t, contextScriptedReader{r}, conf)
+
+	// The period has not yet elapsed.
+	if _, err := readBatch(t, b); err != types.ErrTimeout {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrTimeout)
+	}
+
+	<-time.After(time.Millisecond * 60)
+	act, err := readBatch(t, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"foo"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong batch: %v != %v", act, exp)
+	}
+}
+
+func TestBatcherPeriodBlocked(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	r, err := NewLines(
+		func() (io.Reader, error) { return pr, nil },
+		func() {},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads of the handle block once the first line has been read.
+	conf := batch.NewPolicyConfig()
+	conf.Period = "50ms"
+	b := newTestBatcher(t, r, conf)
+
+	go func() {
+		pw.Write([]byte("foo\n"))
+	}()
+
+	type result struct {
+		parts []string
+		err   error
+	}
+	resChan := make(chan result)
+	go func() {
+		parts, err := readBatch(t, b)
+		resChan <- result{parts: parts, err: err}
+	}()
+	var res result
+	select {
+	case res = <-resChan:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for batch")
+	}
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if exp := []string{"foo"}; !reflect.DeepEqual(res.parts, exp) {
+		t.Errorf("Wrong batch: %v != %v", res.parts, exp)
+	}
+
+	go func() {
+		pw.Write([]byte("bar\n"))
+	}()
+	act, err := readBatch(t, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"bar"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong batch: %v != %v", act, exp)
+	}
+}
+
+func TestBatcherPeriodUnsupported(t *testing.T) {
+	conf := batch.NewPolicyConfig()
+	conf.Period = "50ms"
+	policy, err := batch.NewPolicy(conf, nil, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewBatcher(&scriptedReader{}, policy); err == nil {
+		t.Error("Expected error from reader without read contexts")
+	}
+}
+
+func TestBatcherAcknowledge(t *testing.T) {
+	r := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"foo"}},
+			{content: []string{"bar"}},
+		},
+	}
+
+	conf := batch.NewPolicyConfig()
+	conf.Count = 2
+	b := newTestBatcher(t, r, conf)
+
+	if _, err := readBatch(t, b); err != nil {
+		t.Fatal(err)
+	}
+
+	errFailed := errors.New("failed")
+	if err := b.Acknowledge(errFailed); err != nil {
+		t.Error(err)
+	}
+	if err := b.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+	if exp, act := []error{errFailed, nil}, r.acks; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong acks: %v != %v", act, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,274 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+A capture stream is a sequence of records, one for each message, where all
+integers are u32 big endian:
+
+| record length | number of parts | part 1 | ... | part N |
+
+Where the record length is the number of bytes that follow it within the
+record, and each part is:
+
+| metadata length | metadata | content length | content |
+
+Where the metadata is a JSON object of string values.
+*/
+
+// ErrBadCaptureRecord is returned when a capture record cannot be parsed.
+var ErrBadCaptureRecord = errors.New("capture record was malformed")
+
+// WriteCaptureRecord writes a message, including the metadata of each part, as
+// a single capture record that can be replayed with a Capture reader. This is
+// the basis of an output that captures messages in order to replay them.
+func WriteCaptureRecord(w io.Writer, msg types.Message) error {
+	var record bytes.Buffer
+	writeLen := func(l int) {
+		var lenBytes [4]byte
+		binary.BigEndian.PutUint32(lenBytes[:], uint32(l))
+		record.Write(lenBytes[:])
+	}
+
+	writeLen(msg.Len())
+	if err := msg.Iter(func(i int, p types.Part) error {
+		meta := map[string]string{}
+		p.Metadata().Iter(func(k, v string) error {
+			meta[k] = v
+			return nil
+		})
+		metaBytes, err := json.Marshal(meta)
+		if err != nil {
+			return fmt.Errorf("failed to serialise metadata of part %v: %v", i, err)
+		}
+		writeLen(len(metaBytes))
+		record.Write(metaBytes)
+		writeLen(len(p.Get()))
+		record.Write(p.Get())
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var lenBytes [4]byte
+	binary.BigEndian.PutUint32(lenBytes[:], uint32(record.Len()))
+	if _, err := w.Write(lenBytes[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(record.Bytes())
+	return err
+}
+
+// parseCaptureRecord parses a capture record, excluding its length, into a
+// message.
+func parseCaptureRecord(b []byte) (types.Message, error) {
+	readChunk := func() ([]byte, error) {
+		if len(b) < 4 {
+			return nil, ErrBadCaptureRecord
+		}
+		l := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint32(len(b)) < l {
+			return nil, ErrBadCaptureRecord
+		}
+		chunk := b[:l]
+		b = b[l:]
+		return chunk, nil
+	}
+
+	if len(b) < 4 {
+		return nil, ErrBadCaptureRecord
+	}
+	numParts := binary.BigEndian.Uint32(b)
+	b = b[4:]
+
+	msg := message.New(nil)
+	for i := uint32(0); i < numParts; i++ {
+		metaBytes, err := readChunk()
+		if err != nil {
+			return nil, err
+		}
+		content, err := readChunk()
+		if err != nil {
+			return nil, err
+		}
+
+		var meta map[string]string
+		if err = json.Unmarshal(metaBytes, &meta); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata of part %v: %v", i, err)
+		}
+		part := message.NewPart(content)
+		for k, v := range meta {
+			part.Metadata().Set(k, v)
+		}
+		msg.Append(part)
+	}
+	if len(b) > 0 {
+		return nil, ErrBadCaptureRecord
+	}
+	return msg, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Capture is a reader implementation that replays messages from capture
+// streams, restoring the metadata of each message part. Capture streams can be
+// written with WriteCaptureRecord.
+type Capture struct {
+	handleCtor func() (io.Reader, error)
+	onClose    func()
+
+	maxRecordSize int
+
+	handle io.Reader
+}
+
+// NewCapture creates a new Capture reader.
+//
+// Callers must provide a constructor function for the target io.Reader, which
+// is called on start up and again each time a reader is exhausted. If the
+// constructor is called but there is no more content to create a Reader for
+// then the error `io.EOF` should be returned and the Capture will close.
+//
+// Callers must also provide an onClose function, which will be called if the
+// Capture has been instructed to shut down. This function should unblock any
+// blocked Read calls.
+func NewCapture(
+	handleCtor func() (io.Reader, error),
+	onClose func(),
+	options ...func(c *Capture),
+) *Capture {
+	c := Capture{
+		handleCtor:    handleCtor,
+		onClose:       onClose,
+		maxRecordSize: 64 * 1024 * 1024,
+	}
+	for _, opt := range options {
+		opt(&c)
+	}
+	return &c
+}
+
+//------------------------------------------------------------------------------
+
+// OptCaptureSetMaxRecordSize is a option func that sets the maximum length of
+// a capture record in bytes, which is 64MiB by default. A record that exceeds
+// it results in an error before any memory is allocated for it, which guards
+// against corrupt streams.
+func OptCaptureSetMaxRecordSize(size int) func(c *Capture) {
+	return func(c *Capture) {
+		c.maxRecordSize = size
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (c *Capture) closeHandle() {
+	if c.handle != nil {
+		if closer, ok := c.handle.(io.ReadCloser); ok {
+			closer.Close()
+		}
+		c.handle = nil
+	}
+}
+
+// Connect attempts to open the next capture stream.
+func (c *Capture) Connect() error {
+	if c.handle != nil {
+		return nil
+	}
+
+	var err error
+	if c.handle, err = c.handleCtor(); err != nil {
+		if err == io.EOF {
+			return types.ErrTypeClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// Read attempts to read the next message of the capture stream. Once a stream
+// is exhausted types.ErrNotConnected is returned. A stream that ends part way
+// through a record results in an error and is closed.
+func (c *Capture) Read() (types.Message, error) {
+	if c.handle == nil {
+		return nil, types.ErrNotConnected
+	}
+
+	var lenBytes [4]byte
+	if _, err := io.ReadFull(c.handle, lenBytes[:]); err != nil {
+		c.closeHandle()
+		if err == io.EOF {
+			return nil, types.ErrNotConnected
+		}
+		return nil, fmt.Errorf("failed to read capture record: %v", err)
+	}
+
+	recordLen := binary.BigEndian.Uint32(lenBytes[:])
+	if uint64(recordLen) > uint64(c.maxRecordSize) {
+		c.closeHandle()
+		return nil, fmt.Errorf("capture record of %v bytes exceeds the max record size of %v bytes", recordLen, c.maxRecordSize)
+	}
+	record := make([]byte, recordLen)
+	if _, err := io.ReadFull(c.handle, record); err != nil {
+		c.closeHandle()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to read capture record: %v", err)
+	}
+	return parseCaptureRecord(record)
+}
+
+// Acknowledge confirms whether or not our unacknowledged messages have been
+// successfully propagated or not.
+func (c *Capture) Acknowledge(err error) error {
+	return nil
+}
+
+// CloseAsync shuts down the reader input and stops processing requests.
+func (c *Capture) CloseAsync() {
+	c.onClose()
+}
+
+// WaitForClose blocks until the reader input has closed down.
+func (c *Capture) WaitForClose(timeout time.Duration) error {
+	c.closeHandle()
+	return nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,188 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func TestCaptureRoundTrip(t *testing.T) {
+	type testPart struct {
+		content string
+		meta    map[string]string
+	}
+	input := [][]testPart{
+		{{"foo", map[string]string{"a": "1"}}},
+		{{"bar", map[string]string{}}, {"", map[string]string{"b": "2", "c": "3"}}},
+		{{"baz", map[string]string{"d": "4"}}},
+	}
+
+	var handles []io.Reader
+	for _, parts := range [][][]testPart{input[:2], input[2:]} {
+		var buf bytes.Buffer
+		for _, msgParts := range parts {
+			msg := message.New(nil)
+			for _, p := range msgParts {
+				part := message.NewPart([]byte(p.content))
+				for k, v := range p.meta {
+					part.Metadata().Set(k, v)
+				}
+				msg.Append(part)
+			}
+			if err := WriteCaptureRecord(&buf, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		handles = append(handles, &buf)
+	}
+
+	r := NewCapture(func() (io.Reader, error) {
+		if len(handles) == 0 {
+			return nil, io.EOF
+		}
+		handle := handles[0]
+		handles = handles[1:]
+		return handle, nil
+	}, func() {})
+
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var act [][]testPart
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parts []testPart
+		msg.Iter(func(i int, p types.Part) error {
+			meta := map[string]string{}
+			p.Metadata().Iter(func(k, v string) error {
+				meta[k] = v
+				return nil
+			})
+			parts = append(parts, testPart{string(p.Get()), meta})
+			return nil
+		})
+		act = append(act, parts)
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if !reflect.DeepEqual(act, input) {
+		t.Errorf("Wrong result: %v != %v", act, input)
+	}
+}
+
+func TestCaptureBadRecords(t *testing.T) {
+	var valid bytes.Buffer
+	if err := WriteCaptureRecord(&valid, message.New([][]byte{[]byte("foo")})); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"truncated length":   valid.Bytes()[:2],
+		"truncated record":   valid.Bytes()[:valid.Len()-1],
+		"bad part count":     {0, 0, 0, 4, 0, 0, 0, 2},
+		"bad metadata":       {0, 0, 0, 14, 0, 0, 0, 1, 0, 0, 0, 2, '[', ']', 0, 0, 0, 0},
+		"trailing bytes":     append([]byte{0, 0, 0, 5, 0, 0, 0, 0}, 0),
+		"bad content length": {0, 0, 0, 14, 0, 0, 0, 1, 0, 0, 0, 2, '{', '}', 0, 0, 0, 9},
+	}
+
+	for name, input := range tests {
+		handle := bytes.NewReader(input)
+		r := NewCapture(func() (io.Reader, error) {
+			if handle == nil {
+				return nil, io.EOF
+			}
+			h := handle
+			handle = nil
+			return h, nil
+		}, func() {})
+		if err := r.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Read(); err == nil || err == types.ErrNotConnected {
+			t.Errorf("%v: expected error, received: %v", name, err)
+		}
+	}
+}
+
+func TestCaptureMaxRecordSize(t *testing.T) {
+	var input bytes.Buffer
+	if err := WriteCaptureRecord(&input, message.New([][]byte{[]byte("foo")})); err != nil {
+		t.Fatal(err)
+	}
+	record := input.Bytes()
+
+	handles := [][]byte{record, {0xff, 0xff, 0xff, 0xff}}
+	r := NewCapture(func() (io.Reader, error) {
+		if len(handles) == 0 {
+			return nil, io.EOF
+		}
+		h := bytes.NewReader(handles[0])
+		handles = handles[1:]
+		return h, nil
+	}, func() {}, OptCaptureSetMaxRecordSize(len(record)-4))
+
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Fatalf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+
+	// A corrupt length is rejected rather than allocated.
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err == nil || !strings.Contains(err.Error(), "exceeds the max record size") {
+		t.Errorf("Expected max record size error, received: %v", err)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,217 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// chunkerPartError is a PartError containing the failed parts of the messages
+// read from the child of a Chunker.
+type chunkerPartError struct {
+	error
+	failed []int
+}
+
+func (e chunkerPartError) FailedParts() []int {
+	return e.failed
+}
+
+// Chunker is a wrapper for reader.Type implementations that splits message
+// parts larger than a maximum size into chunks. A message without oversized
+// parts is passed through unchanged, otherwise each of its parts is emitted as
+// a message of its own, with oversized parts emitted as a message per chunk.
+// Chunks carry the metadata of their part along with the fields `chunk_index`,
+// starting at 0, and `chunk_total`. Acknowledgements are only forwarded to the
+// child once all messages produced from its messages have been acknowledged,
+// with failures mapped back to the parts they came from. Chunker implements
+// reader.Type.
+type Chunker struct {
+	r        Type
+	maxBytes int
+
+	// Messages produced from the last message of the child that are yet to
+	// be read, along with the child part that each came from.
+	pending       []types.Message
+	pendingOrigin []int
+
+	// The child part of each part read since the last acknowledgement, and
+	// the number of parts read from the child since the last acknowledgement
+	// forwarded to it.
+	unacked    []int
+	childParts int
+
+	// Failures accumulated until they can be forwarded to the child.
+	failed map[int]struct{}
+	err    error
+}
+
+// NewChunker returns a new Chunker wrapper around a reader.Type, where message
+// parts larger than maxBytes are split into chunks of at most maxBytes, which
+// must be greater than zero.
+func NewChunker(r Type, maxBytes int) (*Chunker, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("max bytes must be greater than zero: %v", maxBytes)
+	}
+	return &Chunker{
+		r:        r,
+		maxBytes: maxBytes,
+		failed:   map[int]struct{}{},
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Connect attempts to establish a connection to the source, if unsuccessful
+// returns an error. If the attempt is successful (or not necessary) returns
+// nil.
+func (c *Chunker) Connect() error {
+	return c.r.Connect()
+}
+
+// Read attempts to read a new message from the source, returning the next
+// chunk of an oversized message if any remain.
+func (c *Chunker) Read() (types.Message, error) {
+	if len(c.pending) > 0 {
+		return c.popPending(), nil
+	}
+
+	msg, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	offset := c.childParts
+	c.childParts += msg.Len()
+
+	oversized := false
+	msg.Iter(func(_ int, p types.Part) error {
+		if len(p.Get()) > c.maxBytes {
+			oversized = true
+		}
+		return nil
+	})
+	if !oversized {
+		for i := 0; i < msg.Len(); i++ {
+			c.unacked = append(c.unacked, offset+i)
+		}
+		return msg, nil
+	}
+
+	msg.Iter(func(i int, p types.Part) error {
+		data := p.Get()
+		if len(data) <= c.maxBytes {
+			c.pushPending(p, offset+i)
+			return nil
+		}
+		total := (len(data) + c.maxBytes - 1) / c.maxBytes
+		for j := 0; j < total; j++ {
+			end := (j + 1) * c.maxBytes
+			if end > len(data) {
+				end = len(data)
+			}
+			chunk := p.Copy()
+			chunk.Set(data[j*c.maxBytes : end])
+			chunk.Metadata().Set("chunk_index", strconv.Itoa(j))
+			chunk.Metadata().Set("chunk_total", strconv.Itoa(total))
+			c.pushPending(chunk, offset+i)
+		}
+		return nil
+	})
+	return c.popPending(), nil
+}
+
+func (c *Chunker) pushPending(p types.Part, origin int) {
+	msg := message.New(nil)
+	msg.Append(p)
+	c.pending = append(c.pending, msg)
+	c.pendingOrigin = append(c.pendingOrigin, origin)
+}
+
+func (c *Chunker) popPending() types.Message {
+	msg := c.pending[0]
+	c.unacked = append(c.unacked, c.pendingOrigin[0])
+	c.pending, c.pendingOrigin = c.pending[1:], c.pendingOrigin[1:]
+	if len(c.pending) == 0 {
+		c.pending, c.pendingOrigin = nil, nil
+	}
+	return msg
+}
+
+// Acknowledge instructs whether messages read since the last Acknowledge call
+// were successfully propagated. The acknowledgement is held back from the
+// child until all messages produced from the messages it has read are
+// acknowledged.
+func (c *Chunker) Acknowledge(err error) error {
+	if err != nil {
+		c.err = err
+		if pErr, ok := err.(PartError); ok {
+			for _, i := range pErr.FailedParts() {
+				if i >= 0 && i < len(c.unacked) {
+					c.failed[c.unacked[i]] = struct{}{}
+				}
+			}
+		} else {
+			for _, origin := range c.unacked {
+				c.failed[origin] = struct{}{}
+			}
+		}
+	}
+	c.unacked = nil
+	if len(c.pending) > 0 {
+		return nil
+	}
+
+	var childErr error
+	if len(c.failed) > 0 {
+		childErr = c.err
+		if len(c.failed) < c.childParts {
+			failed := make([]int, 0, len(c.failed))
+			for i := range c.failed {
+				failed = append(failed, i)
+			}
+			sort.Ints(failed)
+			childErr = chunkerPartError{error: c.err, failed: failed}
+		}
+	}
+	c.childParts, c.failed, c.err = 0, map[int]struct{}{}, nil
+	return c.r.Acknowledge(childErr)
+}
+
+// CloseAsync triggers the asynchronous closing of the reader.
+func (c *Chunker) CloseAsync() {
+	c.r.CloseAsync()
+}
+
+// WaitForClose blocks until either the reader is finished closing or a timeout
+// occurs.
+func (c *Chunker) WaitForClose(tout time.Duration) error {
+	return c.r.WaitForClose(tout)
+}
+
+//------------------------------------------------------------------------------
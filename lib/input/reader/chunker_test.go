@@ -0,0 +1,135 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func TestChunkerSplit(t *testing.T) {
+	child := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"foo"}},
+			{content: []string{"abcdefgh", "bar"}},
+		},
+	}
+	c, err := NewChunker(child, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var act []string
+	var ackCounts []int
+	for {
+		msg, err := c.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Iter(func(_ int, p types.Part) error {
+			meta := p.Metadata()
+			act = append(act, string(p.Get())+":"+meta.Get("chunk_index")+"/"+meta.Get("chunk_total"))
+			return nil
+		})
+		if err = c.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+		ackCounts = append(ackCounts, len(child.acks))
+	}
+
+	exp := []string{"foo:/", "abc:0/3", "def:1/3", "gh:2/3", "bar:/"}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+	// The child is only acknowledged once all chunks are acknowledged.
+	if exp := []int{1, 1, 1, 1, 2}; !reflect.DeepEqual(ackCounts, exp) {
+		t.Errorf("Wrong ack counts: %v != %v", ackCounts, exp)
+	}
+	if exp := []error{nil, nil}; !reflect.DeepEqual(child.acks, exp) {
+		t.Errorf("Wrong acks: %v != %v", child.acks, exp)
+	}
+}
+
+func TestChunkerAckErrors(t *testing.T) {
+	child := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"abcdef", "foo", "bar"}},
+			{content: []string{"abcdef"}},
+		},
+	}
+	c, err := NewChunker(child, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errTest := errors.New("test error")
+
+	// Chunks of the first part, followed by the second and third parts.
+	acks := []error{nil, errTest, nil, nil}
+	for _, ack := range acks {
+		if _, err := c.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Acknowledge(ack); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(child.acks) != 1 {
+		t.Fatalf("Expected one child ack, received: %v", child.acks)
+	}
+	pErr, ok := child.acks[0].(PartError)
+	if !ok {
+		t.Fatalf("Expected part error, received: %v", child.acks[0])
+	}
+	if exp, act := []int{0}, pErr.FailedParts(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong failed parts: %v != %v", act, exp)
+	}
+
+	// Failing every chunk of a single part message forwards the error as is.
+	for i := 0; i < 2; i++ {
+		if _, err := c.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Acknowledge(errTest); err != nil {
+		t.Error(err)
+	}
+	if exp, act := errTest, child.acks[1]; exp != act {
+		t.Errorf("Wrong ack: %v != %v", act, exp)
+	}
+}
+
+func TestChunkerBadMaxBytes(t *testing.T) {
+	for _, maxBytes := range []int{0, -1} {
+		if _, err := NewChunker(&scriptedReader{}, maxBytes); err == nil {
+			t.Errorf("Expected error from max bytes %v", maxBytes)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// Compressor is a wrapper for reader.Type implementations that compresses the
+// contents of each message part read and sets the algorithm used as the
+// metadata field `content_encoding` of the part.
+type Compressor struct {
+	r         Type
+	algorithm string
+	compress  func([]byte) ([]byte, error)
+}
+
+// NewCompressor returns a new Compressor wrapper around a reader.Type. Valid
+// algorithms are "gzip" and "zstd".
+func NewCompressor(r Type, algorithm string) (*Compressor, error) {
+	c := &Compressor{
+		r:         r,
+		algorithm: algorithm,
+	}
+	switch algorithm {
+	case "gzip":
+		c.compress = gzipCompress
+	case "zstd":
+		c.compress = func(b []byte) ([]byte, error) {
+			return zstd.Compress(nil, b)
+		}
+	default:
+		return nil, fmt.Errorf("compression algorithm not recognised: %v", algorithm)
+	}
+	return c, nil
+}
+
+func gzipCompress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//------------------------------------------------------------------------------
+
+// Connect attempts to establish a connection to the source, if unsuccessful
+// returns an error. If the attempt is successful (or not necessary) returns
+// nil.
+func (c *Compressor) Connect() error {
+	return c.r.Connect()
+}
+
+// Acknowledge instructs whether messages read since the last Acknowledge call
+// were successfully propagated.
+func (c *Compressor) Acknowledge(err error) error {
+	return c.r.Acknowledge(err)
+}
+
+// Read attempts to read a new message from the source and returns a copy of it
+// with each part compressed. The message of the source is left unchanged, as it
+// might be read again if it is resent. A part that fails to be compressed is
+// left as it was read, without a `content_encoding` metadata field.
+func (c *Compressor) Read() (types.Message, error) {
+	msg, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	msg = msg.Copy()
+	msg.Iter(func(_ int, p types.Part) error {
+		compressed, err := c.compress(p.Get())
+		if err != nil {
+			return nil
+		}
+		p.Set(compressed)
+		p.Metadata().Set("content_encoding", c.algorithm)
+		return nil
+	})
+	return msg, nil
+}
+
+// CloseAsync triggers the asynchronous closing of the reader.
+func (c *Compressor) CloseAsync() {
+	c.r.CloseAsync()
+}
+
+// WaitForClose blocks until either the reader is finished closing or a timeout
+// occurs.
+func (c *Compressor) WaitForClose(tout time.Duration) error {
+	return c.r.WaitForClose(tout)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+)
+
+//------------------------------------------------------------------------------
+
+func TestCompressorGzip(t *testing.T) {
+	rdr := newMockReader()
+	rdr.msgToSnd = message.New([][]byte{[]byte("foo"), []byte("bar")})
+
+	r, err := NewCompressor(rdr, "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		rdr.readChan <- nil
+		rdr.readChan <- errors.New("nope")
+		rdr.ackChan <- errors.New("ack failed")
+	}()
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range []string{"foo", "bar"} {
+		part := msg.Get(i)
+		if act := part.Metadata().Get("content_encoding"); act != "gzip" {
+			t.Errorf("Wrong content_encoding of part %v: %v != gzip", i, act)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(part.Get()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(act) != exp {
+			t.Errorf("Wrong contents of part %v: %s != %v", i, act, exp)
+		}
+	}
+	if _, err = r.Read(); err == nil || err.Error() != "nope" {
+		t.Errorf("Expected error, received: %v", err)
+	}
+	if err = r.Acknowledge(nil); err == nil || err.Error() != "ack failed" {
+		t.Errorf("Expected ack error, received: %v", err)
+	}
+}
+
+func TestCompressorResend(t *testing.T) {
+	rdr := newMockReader()
+	rdr.msgToSnd = message.New([][]byte{[]byte("foo")})
+
+	r, err := NewCompressor(NewPreserver(rdr), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		rdr.readChan <- nil
+		rdr.ackChan <- nil
+	}()
+
+	// A resent message is compressed once from its original contents.
+	for _, ack := range []error{errors.New("nope"), nil} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(msg.Get(0).Get()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := "foo"; string(act) != exp {
+			t.Errorf("Wrong contents: %s != %v", act, exp)
+		}
+		if err = r.Acknowledge(ack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp, act := "foo", string(rdr.msgToSnd.Get(0).Get()); exp != act {
+		t.Errorf("Child message was modified: %v != %v", act, exp)
+	}
+}
+
+func TestCompressorBadAlgorithm(t *testing.T) {
+	if _, err := NewCompressor(newMockReader(), "lz4"); err == nil {
+		t.Error("Expected error from unrecognised algorithm")
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,233 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// FileTail is a reader implementation that follows a file in the same way as
+// `tail -F`, reading lines as they are appended to the file and reopening the
+// file when it is truncated, renamed or recreated.
+type FileTail struct {
+	path         string
+	pollInterval time.Duration
+	startAtEnd   bool
+	opened       bool
+
+	linesOpts []func(r *Lines)
+	lines     *Lines
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// NewFileTail creates a new FileTail reader that follows the file at a path.
+func NewFileTail(path string, options ...func(t *FileTail)) (*FileTail, error) {
+	t := FileTail{
+		path:         path,
+		pollInterval: time.Second,
+		closeChan:    make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(&t)
+	}
+
+	var err error
+	if t.lines, err = NewLines(t.open, t.close, t.linesOpts...); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+//------------------------------------------------------------------------------
+
+// OptFileTailSetPollInterval is a option func that sets the interval at which
+// the file is checked for new content and rotation once all of its content has
+// been read.
+func OptFileTailSetPollInterval(interval time.Duration) func(t *FileTail) {
+	return func(t *FileTail) {
+		t.pollInterval = interval
+	}
+}
+
+// OptFileTailSetStartAtEnd is a option func that sets whether the file should
+// initially be read from its end, and therefore only lines appended after the
+// reader connects are read. Files that replace the original after a rotation
+// are always read from the beginning.
+func OptFileTailSetStartAtEnd(startAtEnd bool) func(t *FileTail) {
+	return func(t *FileTail) {
+		t.startAtEnd = startAtEnd
+	}
+}
+
+// OptFileTailSetMaxBuffer is a option func that sets the maximum size of the
+// line parsing buffers.
+func OptFileTailSetMaxBuffer(maxBuffer int) func(t *FileTail) {
+	return func(t *FileTail) {
+		t.linesOpts = append(t.linesOpts, OptLinesSetMaxBuffer(maxBuffer))
+	}
+}
+
+// OptFileTailSetDelimiter is a option func that sets the delimiter (default
+// '\n') used to divide lines (message parts) in the file.
+func OptFileTailSetDelimiter(delimiter string) func(t *FileTail) {
+	return func(t *FileTail) {
+		t.linesOpts = append(t.linesOpts, OptLinesSetDelimiter(delimiter))
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// open is the handle constructor of the underlying Lines reader, and opens the
+// file currently at our path.
+func (t *FileTail) open() (io.Reader, error) {
+	select {
+	case <-t.closeChan:
+		return nil, io.EOF
+	default:
+	}
+
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var offset int64
+	if t.startAtEnd && !t.opened {
+		if offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	t.opened = true
+
+	return &tailHandle{
+		t:      t,
+		file:   file,
+		info:   info,
+		offset: offset,
+	}, nil
+}
+
+func (t *FileTail) close() {
+	t.closeOnce.Do(func() {
+		close(t.closeChan)
+	})
+}
+
+// tailHandle reads from a followed file, blocking at the end of the file until
+// either more content is appended or the file is rotated, at which point it
+// returns io.EOF.
+type tailHandle struct {
+	t      *FileTail
+	file   *os.File
+	info   os.FileInfo
+	offset int64
+}
+
+func (h *tailHandle) Read(p []byte) (int, error) {
+	for {
+		n, err := h.file.Read(p)
+		h.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// We've read everything within the file, if it's been replaced then
+		// one last read ensures we don't miss content written to it before
+		// the replacement.
+		info, serr := os.Stat(h.t.path)
+		if serr == nil && !os.SameFile(info, h.info) {
+			if n, err = h.file.Read(p); n > 0 {
+				h.offset += int64(n)
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+		if serr == nil && info.Size() < h.offset {
+			// The file has been truncated, therefore everything within it is
+			// new content.
+			if _, err = h.file.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			h.offset = 0
+			continue
+		}
+
+		select {
+		case <-time.After(h.t.pollInterval):
+		case <-h.t.closeChan:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (h *tailHandle) Close() error {
+	return h.file.Close()
+}
+
+//------------------------------------------------------------------------------
+
+// Connect attempts to open the file being followed.
+func (t *FileTail) Connect() error {
+	return t.lines.Connect()
+}
+
+// Read attempts to read a new line from the file, blocking until one is
+// available.
+func (t *FileTail) Read() (types.Message, error) {
+	return t.lines.Read()
+}
+
+// Acknowledge confirms whether or not our unacknowledged messages have been
+// successfully propagated or not.
+func (t *FileTail) Acknowledge(err error) error {
+	return t.lines.Acknowledge(err)
+}
+
+// CloseAsync shuts down the reader and stops following the file.
+func (t *FileTail) CloseAsync() {
+	t.lines.CloseAsync()
+}
+
+// WaitForClose blocks until the reader has closed down.
+func (t *FileTail) WaitForClose(timeout time.Duration) error {
+	return t.lines.WaitForClose(timeout)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,165 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func readTailLine(t *testing.T, r *FileTail) string {
+	t.Helper()
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+		return string(msg.Get(0).Get())
+	}
+}
+
+func TestFileTailRotation(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_tail_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "foo.log")
+	if err = ioutil.WriteFile(path, []byte("foo\nbar\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewFileTail(path, OptFileTailSetPollInterval(time.Millisecond*10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{"foo", "bar"} {
+		if act := readTailLine(t, r); act != exp {
+			t.Errorf("Wrong line: %v != %v", act, exp)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("baz"))
+	file.Close()
+
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte("qux\nquz\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{"baz", "qux", "quz"} {
+		if act := readTailLine(t, r); act != exp {
+			t.Errorf("Wrong line: %v != %v", act, exp)
+		}
+	}
+
+	if err = ioutil.WriteFile(path, []byte("new\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "new", readTailLine(t, r); act != exp {
+		t.Errorf("Wrong line after truncation: %v != %v", act, exp)
+	}
+
+	go func() {
+		<-time.After(time.Millisecond * 50)
+		r.CloseAsync()
+	}()
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+	if err = r.Connect(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrTypeClosed)
+	}
+	if err = r.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileTailStartAtEnd(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_tail_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "foo.log")
+	if err = ioutil.WriteFile(path, []byte("old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewFileTail(
+		path,
+		OptFileTailSetPollInterval(time.Millisecond*10),
+		OptFileTailSetStartAtEnd(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		r.CloseAsync()
+		if err := r.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("new\n"))
+	file.Close()
+
+	if exp, act := "new", readTailLine(t, r); act != exp {
+		t.Errorf("Wrong line: %v != %v", act, exp)
+	}
+}
+
+//------------------------------------------------------------------------------
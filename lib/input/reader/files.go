@@ -21,13 +21,34 @@
 package reader
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/DataDog/zstd"
+	"github.com/Jeffail/benthos/v3/lib/log"
 	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
 	"github.com/Jeffail/benthos/v3/lib/types"
 )
 
@@ -35,95 +56,2104 @@ import (
 
 // FilesConfig contains configuration for the Files input type.
 type FilesConfig struct {
-	Path string `json:"path" yaml:"path"`
+	Path             string            `json:"path" yaml:"path"`
+	Include          string            `json:"include" yaml:"include"`
+	Exclude          string            `json:"exclude" yaml:"exclude"`
+	Recursive        bool              `json:"recursive" yaml:"recursive"`
+	Sort             string            `json:"sort" yaml:"sort"`
+	DeleteOnFinish   bool              `json:"delete_on_finish" yaml:"delete_on_finish"`
+	Codec            string            `json:"codec" yaml:"codec"`
+	LineDelimited    bool              `json:"line_delimited" yaml:"line_delimited"`
+	Delim            string            `json:"delimiter" yaml:"delimiter"`
+	MaxBuffer        int               `json:"max_buffer" yaml:"max_buffer"`
+	ReadChunkSize    int               `json:"read_chunk_size" yaml:"read_chunk_size"`
+	MaxFileSize      int64             `json:"max_file_size" yaml:"max_file_size"`
+	HeadBytes        int64             `json:"head_bytes" yaml:"head_bytes"`
+	SkipLeadingLines int               `json:"skip_leading_lines" yaml:"skip_leading_lines"`
+	HeaderMetadata   bool              `json:"header_metadata" yaml:"header_metadata"`
+	FromManifest     bool              `json:"from_manifest" yaml:"from_manifest"`
+	OnError          string            `json:"on_error" yaml:"on_error"`
+	RetryFailed      bool              `json:"retry_failed" yaml:"retry_failed"`
+	Checkpoint       string            `json:"checkpoint" yaml:"checkpoint"`
+	Hash             string            `json:"hash" yaml:"hash"`
+	MetadataPrefix   string            `json:"metadata_prefix" yaml:"metadata_prefix"`
+	Watch            bool              `json:"watch" yaml:"watch"`
+	PollInterval     string            `json:"poll_interval" yaml:"poll_interval"`
+	MaxPollInterval  string            `json:"max_poll_interval" yaml:"max_poll_interval"`
+	GroupByDir       bool              `json:"group_by_dir" yaml:"group_by_dir"`
+	Roots            []string          `json:"roots" yaml:"roots"`
+	Paths            []string          `json:"paths" yaml:"paths"`
+	ExpandEnv        bool              `json:"expand_env" yaml:"expand_env"`
+	SkipEmpty        bool              `json:"skip_empty" yaml:"skip_empty"`
+	SkipHidden       bool              `json:"skip_hidden" yaml:"skip_hidden"`
+	RelativePaths    bool              `json:"relative_paths" yaml:"relative_paths"`
+	SpecialFiles     string            `json:"special_files" yaml:"special_files"`
+	SpecialTimeout   string            `json:"special_files_timeout" yaml:"special_files_timeout"`
+	StartupTimeout   string            `json:"startup_timeout" yaml:"startup_timeout"`
+	CountFiles       bool              `json:"count_files" yaml:"count_files"`
+	NewerThan        string            `json:"newer_than" yaml:"newer_than"`
+	OlderThan        string            `json:"older_than" yaml:"older_than"`
+	ExtDelims        map[string]string `json:"extension_delimiters" yaml:"extension_delimiters"`
+	EmitEOD          bool              `json:"emit_eod" yaml:"emit_eod"`
+	DetectType       bool              `json:"detect_content_type" yaml:"detect_content_type"`
+	Symlinks         string            `json:"symlinks" yaml:"symlinks"`
+	TailLines        int               `json:"tail_lines" yaml:"tail_lines"`
+	ListOnly         bool              `json:"list_only" yaml:"list_only"`
+	MaxConcurrency   int               `json:"max_concurrency" yaml:"max_concurrency"`
+	Archive          string            `json:"archive" yaml:"archive"`
+	StabilityWindow  string            `json:"stability_window" yaml:"stability_window"`
 }
 
 // NewFilesConfig creates a new FilesConfig with default values.
 func NewFilesConfig() FilesConfig {
 	return FilesConfig{
-		Path: "",
+		Path:             "",
+		Include:          "",
+		Exclude:          "",
+		Recursive:        true,
+		Sort:             "none",
+		DeleteOnFinish:   false,
+		Codec:            "none",
+		LineDelimited:    false,
+		Delim:            "",
+		MaxBuffer:        1000000,
+		ReadChunkSize:    32768,
+		MaxFileSize:      0,
+		HeadBytes:        0,
+		SkipLeadingLines: 0,
+		HeaderMetadata:   false,
+		FromManifest:     false,
+		OnError:          "abort",
+		RetryFailed:      true,
+		Checkpoint:       "",
+		Hash:             "none",
+		MetadataPrefix:   "",
+		Watch:            false,
+		PollInterval:     "1s",
+		MaxPollInterval:  "",
+		GroupByDir:       false,
+		Roots:            []string{},
+		Paths:            []string{},
+		ExpandEnv:        false,
+		SkipEmpty:        false,
+		SkipHidden:       false,
+		RelativePaths:    false,
+		SpecialFiles:     "skip",
+		SpecialTimeout:   "5s",
+		StartupTimeout:   "",
+		CountFiles:       false,
+		NewerThan:        "",
+		OlderThan:        "",
+		ExtDelims:        map[string]string{},
+		EmitEOD:          false,
+		DetectType:       false,
+		Symlinks:         "skip",
+		TailLines:        0,
+		ListOnly:         false,
+		MaxConcurrency:   1,
+		Archive:          "none",
+		StabilityWindow:  "",
 	}
 }
 
+// ValidateFields checks that the fields of the config are valid without
+// searching for files. Unlike a failed search, an error returned by it cannot
+// be resolved by trying again later.
+func (c FilesConfig) ValidateFields() error {
+	_, err := newFiles(c, log.Noop(), metrics.Noop())
+	return err
+}
+
+// Validate checks that the config is valid and that at least one file can be
+// found at the configured path, without opening the contents of any files.
+func (c FilesConfig) Validate() error {
+	f, err := newFiles(c, log.Noop(), metrics.Noop())
+	if err != nil {
+		return err
+	}
+	paths := strings.Join(f.roots(), "', '")
+	if err = f.findTargets(); err != nil {
+		return fmt.Errorf("failed to find files at path '%v': %v", paths, err)
+	}
+	for _, target := range f.targets {
+		if target.err != nil {
+			return fmt.Errorf("failed to find file '%v': %v", target.path, target.err)
+		}
+	}
+	if len(f.targets) == 0 {
+		return fmt.Errorf("no files found at path '%v'", paths)
+	}
+	return nil
+}
+
 //------------------------------------------------------------------------------
 
-// Files is an input type that reads file contents at a path as messages.
-type Files struct {
-	targets []string
+// fileTarget is a file to be read along with the information gathered about it
+// when it was found. A target listed in a manifest that could not be found has
+// an error instead of information. The root is the index of the configured
+// root that the file was found within, and rel is the path of the file
+// relative to that root. The index is the position of the file amongst all
+// files to be read starting at 1, or zero if files are not being counted.
+type fileTarget struct {
+	path  string
+	root  int
+	rel   string
+	index int
+	info  os.FileInfo
+	err   error
+
+	// The detected content type of the file, if enabled.
+	contentType string
+
+	// The codec applied when reading the file, if a codec is configured.
+	codec string
+
+	// Whether the contents of the file were cut short by head_bytes.
+	truncated bool
+}
+
+// Files is an input type that reads file contents at a path as messages.
+type Files struct {
+	conf  FilesConfig
+	delim []byte
+
+	// Delimiters keyed by lower case file extension, including the dot.
+	extDelims map[string][]byte
+
+	// The targets found when the reader was created are prepared for reading
+	// by the first successful call to Connect.
+	targets   []fileTarget
+	pending   []string
+	connected bool
+
+	lines   *Lines
+	current *fileTarget
+	failed  *fileTarget
+	unacked bool
+
+	// Set when a message of the current file is rejected and is not going to
+	// be retried, which prevents the file from being finished successfully.
+	currentRejected bool
+
+	newHash func() hash.Hash
+	less    func(a, b fileTarget) bool
+
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
+	seen            map[string]struct{}
+
+	// When targets were last found, and the period they must remain unchanged
+	// for before being read.
+	foundAt         time.Time
+	stabilityWindow time.Duration
+
+	specialTimeout time.Duration
+
+	// The number of files that have been counted.
+	total int
+
+	newerThan, olderThan *timeBound
+
+	// The number of targets remaining within each directory, and directories
+	// that have been fully consumed and are awaiting an end of directory
+	// message.
+	dirTargets map[string]int
+	eods       []string
+	lastEOD    bool
+
+	// Files being read concurrently or prefetched deliver their results here,
+	// where prefetched files are read in order by a single goroutine. When
+	// checkpointing, the paths of files in the order they began being read are
+	// kept along with a count of those that have since been completed, so that
+	// the checkpoint never moves past a file that is still in flight.
+	inflight   int
+	results    chan fileResult
+	dispatched []string
+	completed  map[string]int
+
+	prefetch     int
+	prefetchJobs chan fileTarget
+
+	// The archive currently being read, the entry read ahead from it, and an
+	// error encountered whilst reading ahead.
+	archive       archiveEntries
+	archiveTarget fileTarget
+	archiveNext   *archiveEntry
+	archiveErr    error
+
+	onAck func(path string, err error)
+
+	log     log.Modular
+	mErrors metrics.StatCounter
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// NewFiles creates a new Files input type.
+func NewFiles(
+	conf FilesConfig,
+	log log.Modular,
+	stats metrics.Type,
+	options ...func(f *Files),
+) (Type, error) {
+	f, err := newFiles(conf, log, stats)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+	if err = f.initPrefetch(); err != nil {
+		return nil, err
+	}
+	if err = f.findTargets(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// newFiles creates a Files input type without searching for files.
+func newFiles(conf FilesConfig, log log.Modular, stats metrics.Type) (*Files, error) {
+	f := Files{
+		conf:    conf,
+		delim:   []byte(conf.Delim),
+		log:     log,
+		mErrors: stats.GetCounter("files.errors"),
+
+		closeChan: make(chan struct{}),
+	}
+	if len(f.delim) == 0 {
+		f.delim = []byte("\n")
+	}
+	for ext, delim := range conf.ExtDelims {
+		if len(delim) == 0 {
+			return nil, fmt.Errorf("delimiter of extension '%v' must not be empty", ext)
+		}
+		if f.extDelims == nil {
+			f.extDelims = map[string][]byte{}
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		f.extDelims[strings.ToLower(ext)] = []byte(delim)
+	}
+
+	if conf.ReadChunkSize <= 0 {
+		return nil, fmt.Errorf("read chunk size must be greater than zero: %v", conf.ReadChunkSize)
+	}
+	if conf.MaxFileSize < 0 {
+		return nil, fmt.Errorf("max file size must not be negative: %v", conf.MaxFileSize)
+	}
+	if conf.HeadBytes < 0 {
+		return nil, fmt.Errorf("head bytes must not be negative: %v", conf.HeadBytes)
+	}
+	if conf.HeadBytes > 0 && (conf.LineDelimited || conf.TailLines > 0) {
+		return nil, errors.New("head_bytes cannot be combined with line_delimited or tail_lines")
+	}
+
+	if err := checkGlob(conf.Include); err != nil {
+		return nil, fmt.Errorf("failed to parse include pattern: %v", err)
+	}
+	if err := checkGlob(conf.Exclude); err != nil {
+		return nil, fmt.Errorf("failed to parse exclude pattern: %v", err)
+	}
+
+	switch conf.Sort {
+	case "none":
+	case "name":
+		f.less = func(a, b fileTarget) bool {
+			return a.path < b.path
+		}
+	case "mod_time":
+		f.less = func(a, b fileTarget) bool {
+			return a.info.ModTime().Before(b.info.ModTime())
+		}
+	case "size":
+		f.less = func(a, b fileTarget) bool {
+			return a.info.Size() < b.info.Size()
+		}
+	default:
+		return nil, fmt.Errorf("sort type not recognised: %v", conf.Sort)
+	}
+
+	switch conf.Codec {
+	case "none", "gzip", "bzip2", "zstd", "auto":
+	default:
+		return nil, fmt.Errorf("codec not recognised: %v", conf.Codec)
+	}
+
+	switch conf.Hash {
+	case "none":
+	case "md5":
+		f.newHash = md5.New
+	case "sha1":
+		f.newHash = sha1.New
+	case "sha256":
+		f.newHash = sha256.New
+	default:
+		return nil, fmt.Errorf("hash algorithm not recognised: %v", conf.Hash)
+	}
+
+	switch conf.OnError {
+	case "abort", "skip":
+	default:
+		return nil, fmt.Errorf("on_error strategy not recognised: %v", conf.OnError)
+	}
+
+	if conf.Watch {
+		var err error
+		if f.pollInterval, err = time.ParseDuration(conf.PollInterval); err != nil {
+			return nil, fmt.Errorf("failed to parse poll interval: %v", err)
+		}
+		f.maxPollInterval = f.pollInterval
+		if len(conf.MaxPollInterval) > 0 {
+			if f.maxPollInterval, err = time.ParseDuration(conf.MaxPollInterval); err != nil {
+				return nil, fmt.Errorf("failed to parse max poll interval: %v", err)
+			}
+			if f.maxPollInterval < f.pollInterval {
+				return nil, fmt.Errorf("max poll interval must not be less than poll interval: %v", conf.MaxPollInterval)
+			}
+		}
+		f.seen = map[string]struct{}{}
+	}
+
+	switch conf.SpecialFiles {
+	case "skip", "error":
+	case "read":
+		var err error
+		if f.specialTimeout, err = time.ParseDuration(conf.SpecialTimeout); err != nil {
+			return nil, fmt.Errorf("failed to parse special files timeout: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("special files strategy not recognised: %v", conf.SpecialFiles)
+	}
+
+	switch conf.Archive {
+	case "none":
+	case "tar", "zip", "auto":
+		if conf.LineDelimited || conf.GroupByDir || conf.ListOnly || conf.TailLines > 0 || conf.MaxConcurrency > 1 {
+			return nil, errors.New("archive cannot be combined with line_delimited, group_by_dir, list_only, tail_lines or max_concurrency")
+		}
+	default:
+		return nil, fmt.Errorf("archive format not recognised: %v", conf.Archive)
+	}
+
+	switch conf.Symlinks {
+	case "follow", "skip", "error":
+	default:
+		return nil, fmt.Errorf("symlinks strategy not recognised: %v", conf.Symlinks)
+	}
+
+	if len(conf.StabilityWindow) > 0 {
+		var err error
+		if f.stabilityWindow, err = time.ParseDuration(conf.StabilityWindow); err != nil {
+			return nil, fmt.Errorf("failed to parse stability window: %v", err)
+		}
+	}
+
+	if len(conf.NewerThan) > 0 {
+		var err error
+		if f.newerThan, err = parseTimeBound(conf.NewerThan); err != nil {
+			return nil, fmt.Errorf("failed to parse newer_than: %v", err)
+		}
+	}
+	if len(conf.OlderThan) > 0 {
+		var err error
+		if f.olderThan, err = parseTimeBound(conf.OlderThan); err != nil {
+			return nil, fmt.Errorf("failed to parse older_than: %v", err)
+		}
+	}
+
+	if len(conf.Roots) > 0 {
+		if len(conf.Path) > 0 {
+			return nil, errors.New("path and roots cannot both be set")
+		}
+		if conf.FromManifest {
+			return nil, errors.New("roots cannot be combined with from_manifest")
+		}
+	}
+	if len(conf.Paths) > 0 && (len(conf.Path) > 0 || len(conf.Roots) > 0 || conf.FromManifest) {
+		return nil, errors.New("paths cannot be combined with path, roots or from_manifest")
+	}
+	if conf.ExpandEnv {
+		var err error
+		if f.conf.Path, err = expandEnv(conf.Path); err != nil {
+			return nil, err
+		}
+		f.conf.Roots = make([]string, len(conf.Roots))
+		for i, root := range conf.Roots {
+			if f.conf.Roots[i], err = expandEnv(root); err != nil {
+				return nil, err
+			}
+		}
+		f.conf.Paths = make([]string, len(conf.Paths))
+		for i, path := range conf.Paths {
+			if f.conf.Paths[i], err = expandEnv(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if conf.GroupByDir && conf.LineDelimited {
+		return nil, errors.New("group_by_dir cannot be combined with line_delimited")
+	}
+	if conf.ListOnly && conf.LineDelimited {
+		return nil, errors.New("list_only cannot be combined with line_delimited")
+	}
+	if conf.TailLines > 0 && (conf.SkipLeadingLines > 0 || conf.HeaderMetadata) {
+		return nil, errors.New("tail_lines cannot be combined with skip_leading_lines or header_metadata")
+	}
+	if conf.MaxConcurrency > 1 {
+		if conf.LineDelimited || conf.GroupByDir {
+			return nil, errors.New("max_concurrency cannot be combined with line_delimited or group_by_dir")
+		}
+		f.results = make(chan fileResult, conf.MaxConcurrency)
+		f.completed = map[string]int{}
+	}
+
+	if conf.LineDelimited {
+		var err error
+		if f.lines, err = NewLines(
+			f.nextHandle,
+			func() {},
+			OptLinesSetDelimiter(string(f.delim)),
+			OptLinesSetHandleDelimiter(func() string {
+				if f.current == nil {
+					return ""
+				}
+				return string(f.delimFor(f.current.path))
+			}),
+			OptLinesSetMaxBuffer(conf.MaxBuffer),
+		); err != nil {
+			return nil, err
+		}
+	}
+	return &f, nil
+}
+
+//------------------------------------------------------------------------------
+
+// OptFilesSetOnAck is a option func that sets a function called with the path
+// of each file that has been read in full once the messages of the file are
+// acknowledged, along with the error they were acknowledged with. A file
+// acknowledged with an error remains pending, and the function is called for it
+// again on each later acknowledgement until one succeeds. The function is
+// called before the file is deleted when delete_on_finish is set.
+func OptFilesSetOnAck(fn func(path string, err error)) func(f *Files) {
+	return func(f *Files) {
+		f.onAck = fn
+	}
+}
+
+// OptFilesSetPrefetch is a option func that sets a number of files to be read
+// ahead of Read by a background goroutine, which bounds the number of file
+// contents held in memory whilst awaiting consumption. Files are delivered in
+// the order that they are found, and a file that has been prefetched is not
+// deleted or checkpointed until it has been read and acknowledged.
+func OptFilesSetPrefetch(k int) func(f *Files) {
+	return func(f *Files) {
+		f.prefetch = k
+	}
+}
+
+// initPrefetch starts the goroutine that reads files ahead of Read when
+// prefetching is enabled.
+func (f *Files) initPrefetch() error {
+	if f.prefetch <= 0 {
+		return nil
+	}
+	if f.conf.LineDelimited || f.conf.GroupByDir || f.conf.Archive != "none" || f.conf.MaxConcurrency > 1 {
+		return errors.New("prefetch cannot be combined with line_delimited, group_by_dir, archive or max_concurrency")
+	}
+	f.results = make(chan fileResult, f.prefetch)
+	f.completed = map[string]int{}
+	f.prefetchJobs = make(chan fileTarget, f.prefetch)
+	go func() {
+		for {
+			select {
+			case target := <-f.prefetchJobs:
+				part, err := f.readPart(target)
+				f.results <- fileResult{target: target, part: part, err: err}
+			case <-f.closeChan:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// findTargets adds the files found at the configured path to our targets. When
+// watching, files that have already been found with the same modification time
+// are ignored.
+func (f *Files) findTargets() error {
+	existing := f.targets
+	f.targets = nil
+	defer func() {
+		f.targets = append(existing, f.targets...)
+		f.foundAt = time.Now()
+	}()
+
+	if f.conf.FromManifest {
+		if err := f.readManifest(f.conf.Path); err != nil {
+			return err
+		}
+	} else if len(f.conf.Paths) > 0 {
+		for _, path := range f.conf.Paths {
+			f.targets = append(f.targets, f.explicitTarget(path))
+		}
+	} else {
+		for i, root := range f.roots() {
+			if err := f.findRootTargets(i, root); err != nil {
+				return err
+			}
+		}
+	}
+
+	if f.seen != nil {
+		found := f.targets
+		f.targets = nil
+		for _, target := range found {
+			key := seenKey(target)
+			if _, exists := f.seen[key]; !exists {
+				f.seen[key] = struct{}{}
+				f.targets = append(f.targets, target)
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces references to environment variables within a configured
+// path, and fails when any of them are unset or empty rather than allowing the
+// path to collapse into a different one.
+func expandEnv(path string) (string, error) {
+	var missing []string
+	expanded := os.Expand(path, func(name string) string {
+		value := os.Getenv(name)
+		if len(value) == 0 {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("path '%v' references environment variables that are unset or empty: %v", path, strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// roots returns the configured root paths to find files within.
+func (f *Files) roots() []string {
+	if len(f.conf.Roots) > 0 {
+		return f.conf.Roots
+	}
+	return []string{f.conf.Path}
+}
+
+// findRootTargets adds the files found at a root path to our targets.
+func (f *Files) findRootTargets(index int, root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		f.addTarget(fileTarget{
+			path: root,
+			root: index,
+			rel:  filepath.Base(root),
+			info: info,
+		})
+		return nil
+	}
+
+	found := len(f.targets)
+	if err = f.walk(index, root); err != nil {
+		return err
+	}
+	if f.less != nil {
+		rootTargets := f.targets[found:]
+		sort.SliceStable(rootTargets, func(i, j int) bool {
+			return f.less(rootTargets[i], rootTargets[j])
+		})
+	}
+	return nil
+}
+
+// waitForTargets blocks until new files are found at the configured path,
+// polling at the configured interval, which backs off towards the max poll
+// interval for as long as no files are found. Returns types.ErrTypeClosed if
+// the reader is closed whilst waiting.
+func (f *Files) waitForTargets() error {
+	interval := f.pollInterval
+	for len(f.targets) == 0 {
+		select {
+		case <-time.After(interval):
+		case <-f.closeChan:
+			return types.ErrTypeClosed
+		}
+		if err := f.findTargets(); err != nil {
+			return err
+		}
+		if err := f.settleTargets(); err != nil {
+			return err
+		}
+		f.countTargets()
+		f.countDirs()
+		interval = f.nextPollInterval(interval)
+	}
+	return nil
+}
+
+// nextPollInterval returns the interval to wait before the next poll after a
+// poll at the given interval found no files, doubling it up to the max.
+func (f *Files) nextPollInterval(interval time.Duration) time.Duration {
+	if interval *= 2; interval > f.maxPollInterval || interval <= 0 {
+		return f.maxPollInterval
+	}
+	return interval
+}
+
+// seenKey returns the key of a target that identifies it as having been found
+// with its current modification time.
+func seenKey(target fileTarget) string {
+	key := target.path
+	if target.info != nil {
+		key += "@" + strconv.FormatInt(target.info.ModTime().UnixNano(), 10)
+	}
+	return key
+}
+
+// settleTargets waits for the stability window to pass since targets were last
+// found, and then checks whether the size or modification time of each file
+// has changed since. Files that are still changing are removed and found again
+// by a later poll when watching, otherwise they are checked again after a
+// further window until they settle.
+func (f *Files) settleTargets() error {
+	if f.stabilityWindow <= 0 {
+		return nil
+	}
+	pending := f.targets
+	f.targets = nil
+	for len(pending) > 0 {
+		select {
+		case <-time.After(time.Until(f.foundAt.Add(f.stabilityWindow))):
+		case <-f.closeChan:
+			f.targets = append(f.targets, pending...)
+			return types.ErrTypeClosed
+		}
+
+		var unsettled []fileTarget
+		for _, target := range pending {
+			if target.err != nil || !target.info.Mode().IsRegular() {
+				f.targets = append(f.targets, target)
+				continue
+			}
+			info, err := os.Stat(target.path)
+			if err != nil || (info.Size() == target.info.Size() && info.ModTime().Equal(target.info.ModTime())) {
+				// Files that have gone are left to fail when read.
+				f.targets = append(f.targets, target)
+				continue
+			}
+			if f.seen != nil {
+				delete(f.seen, seenKey(target))
+				continue
+			}
+			target.info = info
+			unsettled = append(unsettled, target)
+		}
+		pending = unsettled
+		f.foundAt = time.Now()
+	}
+	return nil
+}
+
+// readManifest adds each path listed by a manifest file to our targets in the
+// order that they are listed.
+func (f *Files) readManifest(path string) error {
+	manifest, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest '%v': %v", path, err)
+	}
+	for _, line := range strings.Split(string(manifest), "\n") {
+		if line = strings.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		f.targets = append(f.targets, f.explicitTarget(line))
+	}
+	return nil
+}
+
+// explicitTarget creates a target for a path that has been listed explicitly
+// rather than found by walking, where a path that cannot be consumed is given
+// an error.
+func (f *Files) explicitTarget(path string) fileTarget {
+	target := fileTarget{path: path}
+	if target.info, target.err = os.Stat(path); target.err == nil && target.info.IsDir() {
+		target.err = fmt.Errorf("path '%v' is a directory", path)
+	} else if target.err == nil && !target.info.Mode().IsRegular() && f.conf.SpecialFiles != "read" {
+		target.err = fmt.Errorf("path '%v' is not a regular file", path)
+	}
+	return target
+}
+
+// walk adds all files found within a root directory to our targets.
+func (f *Files) walk(index int, root string) error {
+	// The configured path is followed even when it is a symlink.
+	dir, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	var visited []os.FileInfo
+	return f.walkDir(index, root, dir, root, &visited)
+}
+
+// walkDir walks a directory, where the files found are given paths relative to
+// an alias of the directory, which differs from the directory itself when it is
+// the target of a symlink. Each directory walked is recorded so that symlinks
+// to directories that have already been walked are not followed.
+func (f *Files) walkDir(index int, root, dir, alias string, visited *[]os.FileInfo) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, werr error) error {
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			path = filepath.Join(alias, rel)
+		}
+		if werr != nil {
+			if f.conf.OnError == "skip" && path != root {
+				f.skipFile(path, werr)
+				return nil
+			}
+			return werr
+		}
+		if f.conf.SkipHidden && path != root && strings.HasPrefix(filepath.Base(path), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if !f.conf.Recursive && path != root {
+				return filepath.SkipDir
+			}
+			*visited = append(*visited, info)
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch f.conf.Symlinks {
+			case "skip":
+				return nil
+			case "error":
+				return fmt.Errorf("path '%v' is a symlink", path)
+			}
+			target, err := filepath.EvalSymlinks(path)
+			if err == nil {
+				info, err = os.Stat(target)
+			}
+			if err != nil {
+				if f.conf.OnError == "skip" {
+					f.skipFile(path, err)
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				if !f.conf.Recursive {
+					return nil
+				}
+				for _, v := range *visited {
+					if os.SameFile(v, info) {
+						f.log.Debugf("Not following symlink '%v' to a directory that has already been walked\n", path)
+						return nil
+					}
+				}
+				return f.walkDir(index, root, target, path, visited)
+			}
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !f.matches(rel) {
+			return nil
+		}
+		f.addTarget(fileTarget{path: path, root: index, rel: rel, info: info})
+		return nil
+	})
+}
+
+// addTarget adds a file that has been found to our targets unless it should be
+// ignored. A special file, such as a named pipe or device, is given an error
+// when they are configured as such.
+func (f *Files) addTarget(target fileTarget) {
+	if !target.info.Mode().IsRegular() {
+		switch f.conf.SpecialFiles {
+		case "skip":
+			return
+		case "error":
+			target.err = fmt.Errorf("path '%v' is not a regular file", target.path)
+		}
+	} else if f.conf.SkipEmpty && target.info.Size() == 0 {
+		return
+	}
+	if f.newerThan != nil || f.olderThan != nil {
+		now, modTime := time.Now(), target.info.ModTime()
+		if f.newerThan != nil && !modTime.After(f.newerThan.at(now)) {
+			return
+		}
+		if f.olderThan != nil && !modTime.Before(f.olderThan.at(now)) {
+			return
+		}
+	}
+	f.targets = append(f.targets, target)
+}
+
+// timeBound is a point in time that is either fixed or a duration before the
+// current time.
+type timeBound struct {
+	fixed time.Time
+	ago   time.Duration
+}
+
+// parseTimeBound parses either a duration or an RFC3339 timestamp.
+func parseTimeBound(s string) (*timeBound, error) {
+	if ago, err := time.ParseDuration(s); err == nil {
+		return &timeBound{ago: ago}, nil
+	}
+	fixed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("expected a duration or RFC3339 timestamp: %v", s)
+	}
+	return &timeBound{fixed: fixed}, nil
+}
+
+// at returns the point in time of the bound relative to a current time.
+func (b *timeBound) at(now time.Time) time.Time {
+	if b.fixed.IsZero() {
+		return now.Add(-b.ago)
+	}
+	return b.fixed
+}
+
+// matches returns whether a path found during the walk satisfies the include
+// and exclude patterns, which are tested against the path relative to the
+// root it was found within.
+func (f *Files) matches(rel string) bool {
+	if len(f.conf.Include) > 0 {
+		if match, _ := globMatch(f.conf.Include, rel); !match {
+			return false
+		}
+	}
+	if len(f.conf.Exclude) > 0 {
+		if match, _ := globMatch(f.conf.Exclude, rel); match {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch reports whether name matches a shell file name pattern in the same
+// way as filepath.Match, with the addition that a path segment of `**` matches
+// zero or more directories.
+func globMatch(pattern, name string) (bool, error) {
+	return globMatchSegments(
+		strings.Split(filepath.ToSlash(pattern), "/"),
+		strings.Split(filepath.ToSlash(name), "/"),
+	)
+}
+
+// checkGlob returns an error if a pattern is malformed.
+func checkGlob(pattern string) error {
+	for _, seg := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func globMatchSegments(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if pattern = pattern[1:]; len(pattern) == 0 {
+				return true, nil
+			}
+			for i := 0; i <= len(name); i++ {
+				if match, err := globMatchSegments(pattern, name[i:]); match || err != nil {
+					return match, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		match, err := filepath.Match(pattern[0], name[0])
+		if err != nil || !match {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Connect establishes a connection. When a checkpoint is configured all files
+// up to and including the checkpointed file are skipped.
+func (f *Files) Connect() (err error) {
+	if f.connected {
+		return nil
+	}
+	if len(f.conf.Checkpoint) > 0 {
+		if err = f.skipCheckpointed(); err != nil {
+			return err
+		}
+	}
+	if err = f.settleTargets(); err != nil {
+		return err
+	}
+	f.countTargets()
+	f.countDirs()
+	f.connected = true
+	return nil
+}
+
+// skipCheckpointed removes all targets up to and including the checkpointed
+// file, in the order that they are read. Nothing is skipped when the file is no
+// longer found, which is the case when it was deleted once finished.
+func (f *Files) skipCheckpointed() error {
+	checkpoint, err := ioutil.ReadFile(f.conf.Checkpoint)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read checkpoint '%v': %v", f.conf.Checkpoint, err)
+	}
+	for i, target := range f.targets {
+		if target.path == string(checkpoint) {
+			f.targets = f.targets[i+1:]
+			break
+		}
+	}
+	return nil
+}
+
+// countTargets gives each target that has not yet been counted an index when
+// files are being counted.
+func (f *Files) countTargets() {
+	if !f.conf.CountFiles {
+		return
+	}
+	for i := range f.targets {
+		if f.targets[i].index == 0 {
+			f.total++
+			f.targets[i].index = f.total
+		}
+	}
+}
+
+// countDirs counts the remaining targets within each directory when end of
+// directory messages are enabled.
+func (f *Files) countDirs() {
+	if !f.conf.EmitEOD {
+		return
+	}
+	f.dirTargets = map[string]int{}
+	for _, target := range f.targets {
+		f.dirTargets[filepath.Dir(target.path)]++
+	}
+}
+
+// finishTarget records that a target has been consumed, and if it was the last
+// remaining target of its directory queues an end of directory message.
+func (f *Files) finishTarget(target fileTarget) {
+	if !f.conf.EmitEOD {
+		return
+	}
+	dir := filepath.Dir(target.path)
+	if f.dirTargets[dir]--; f.dirTargets[dir] <= 0 {
+		delete(f.dirTargets, dir)
+		f.eods = append(f.eods, dir)
+	}
+}
+
+// popEOD returns the next queued end of directory message, if any.
+func (f *Files) popEOD() types.Message {
+	if len(f.eods) == 0 {
+		return nil
+	}
+	dir := f.eods[0]
+	f.eods = f.eods[1:]
+
+	part := message.NewPart(nil)
+	part.Metadata().Set(f.metaKey("eod"), "true")
+	part.Metadata().Set(f.metaKey("dir"), dir)
+	msg := message.New(nil)
+	msg.Append(part)
+	return msg
+}
+
+//------------------------------------------------------------------------------
+
+// Read a new Files message.
+func (f *Files) Read() (types.Message, error) {
+	f.lastEOD = false
+	if f.lines != nil {
+		return f.readLine()
+	}
+	if f.results != nil {
+		return f.readConcurrent()
+	}
+
+	for {
+		if f.archive != nil || f.archiveErr != nil {
+			part, err := f.readArchiveEntry()
+			if err != nil {
+				return nil, err
+			}
+			if part != nil {
+				msg := message.New(nil)
+				msg.Append(part)
+				return msg, nil
+			}
+			continue
+		}
+		if msg := f.popEOD(); msg != nil {
+			f.lastEOD = true
+			return msg, nil
+		}
+		if len(f.targets) == 0 {
+			if !f.conf.Watch {
+				return nil, types.ErrTypeClosed
+			}
+			if err := f.waitForTargets(); err != nil {
+				return nil, err
+			}
+		}
+
+		var targets []fileTarget
+		if f.conf.GroupByDir {
+			targets = f.popDir()
+		} else {
+			targets = f.targets[:1]
+			f.targets = f.targets[1:]
+		}
+
+		msg := message.New(nil)
+		var read []string
+		for _, target := range targets {
+			f.finishTarget(target)
+			if f.archiveFor(target.path) != "none" {
+				// The entries of the archive are read at the top of the loop.
+				if err := f.openArchive(target); err != nil {
+					part, err := f.failedPart(target, err)
+					if err != nil {
+						return nil, err
+					}
+					if part != nil {
+						msg.Append(part)
+					}
+				}
+				continue
+			}
+			part, err := f.readPart(target)
+			if err != nil {
+				if part, err = f.failedPart(target, err); err != nil {
+					return nil, err
+				}
+				if part != nil {
+					msg.Append(part)
+				}
+				continue
+			}
+			if f.conf.GroupByDir {
+				part.Metadata().Set(f.metaKey("dir"), filepath.Dir(target.path))
+			}
+			msg.Append(part)
+			read = append(read, target.path)
+		}
+		if msg.Len() == 0 {
+			continue
+		}
+
+		if f.tracksPending() {
+			f.pending = append(f.pending, read...)
+		}
+		return msg, nil
+	}
 }
 
-// NewFiles creates a new Files input type.
-func NewFiles(conf FilesConfig) (Type, error) {
-	f := Files{}
+// fileResult is the outcome of reading a target concurrently.
+type fileResult struct {
+	target fileTarget
+	part   types.Part
+	err    error
+}
+
+// readConcurrent keeps up to max_concurrency files being read in the
+// background and returns each as a message in the order that their reads
+// complete. When prefetching, up to the prefetch count of files are instead
+// queued for the prefetch goroutine, which completes them in order.
+func (f *Files) readConcurrent() (types.Message, error) {
+	limit := f.conf.MaxConcurrency
+	if f.prefetchJobs != nil {
+		limit = f.prefetch
+	}
+	for {
+		if msg := f.popEOD(); msg != nil {
+			f.lastEOD = true
+			return msg, nil
+		}
+		for f.inflight < limit && len(f.targets) > 0 {
+			target := f.targets[0]
+			f.targets = f.targets[1:]
+			if len(f.conf.Checkpoint) > 0 {
+				f.dispatched = append(f.dispatched, target.path)
+			}
+			f.inflight++
+			if f.prefetchJobs != nil {
+				f.prefetchJobs <- target
+				continue
+			}
+			go func() {
+				part, err := f.readPart(target)
+				f.results <- fileResult{target: target, part: part, err: err}
+			}()
+		}
+		if f.inflight == 0 {
+			if !f.conf.Watch {
+				return nil, types.ErrTypeClosed
+			}
+			if err := f.waitForTargets(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var res fileResult
+		select {
+		case res = <-f.results:
+		case <-f.closeChan:
+			return nil, types.ErrTypeClosed
+		}
+		f.inflight--
+		f.finishTarget(res.target)
+
+		if res.err != nil {
+			var err error
+			if res.part, err = f.failedPart(res.target, res.err); err != nil {
+				return nil, err
+			}
+			// Files that could not be read are never acknowledged, and
+			// must not hold back the checkpoint.
+			if len(f.conf.Checkpoint) > 0 {
+				if checkpoint := f.completeDispatched([]string{res.target.path}); len(checkpoint) > 0 {
+					if err := f.writeCheckpoint(checkpoint); err != nil {
+						f.log.Errorf("%v\n", err)
+					}
+				}
+			}
+			if res.part == nil {
+				continue
+			}
+		} else if f.tracksPending() {
+			f.pending = append(f.pending, res.target.path)
+		}
+
+		msg := message.New(nil)
+		msg.Append(res.part)
+		return msg, nil
+	}
+}
+
+// popDir removes the next target from our targets along with all other
+// targets within the same directory, and returns them.
+func (f *Files) popDir() []fileTarget {
+	dir := filepath.Dir(f.targets[0].path)
+
+	var popped []fileTarget
+	remaining := f.targets[:0]
+	for _, target := range f.targets {
+		if filepath.Dir(target.path) == dir {
+			popped = append(popped, target)
+		} else {
+			remaining = append(remaining, target)
+		}
+	}
+	f.targets = remaining
+	return popped
+}
 
-	if info, err := os.Stat(conf.Path); err != nil {
+// readPart reads a target file into a message part. When only listing files
+// the part is empty and carries the metadata of the file alone.
+func (f *Files) readPart(target fileTarget) (types.Part, error) {
+	if target.err != nil {
+		return nil, target.err
+	}
+	if f.conf.ListOnly {
+		part := message.NewPart(nil)
+		f.setMetadata(part, target)
+		return part, nil
+	}
+	msgBytes, digest, err := f.readFile(&target)
+	if err != nil {
 		return nil, err
-	} else if !info.IsDir() {
-		f.targets = append(f.targets, conf.Path)
-		return &f, nil
 	}
+	return f.contentPart(target, f.delimFor(target.path), msgBytes, digest), nil
+}
 
-	err := filepath.Walk(conf.Path, func(path string, info os.FileInfo, werr error) error {
-		if werr != nil {
-			return werr
+// contentPart creates a message part from the contents of a file, or an entry
+// of an archive file.
+func (f *Files) contentPart(target fileTarget, delim, msgBytes []byte, digest string) types.Part {
+	if f.conf.DetectType {
+		// Only the first 512 bytes are considered.
+		target.contentType = http.DetectContentType(msgBytes)
+	}
+	msgBytes, header := f.cutLeadingLines(msgBytes, delim)
+
+	part := message.NewPart(msgBytes)
+	f.setMetadata(part, target)
+	if header != nil {
+		part.Metadata().Set(f.metaKey("header"), string(header))
+	}
+	if f.newHash != nil {
+		part.Metadata().Set(f.metaKey("hash"), digest)
+		part.Metadata().Set(f.metaKey("hash_algorithm"), f.conf.Hash)
+	}
+	return part
+}
+
+// failedPart handles a target that could not be read according to the on_error
+// strategy. When reading from a manifest a part reporting the error is
+// returned, and when skipping the target both the part and error are nil.
+func (f *Files) failedPart(target fileTarget, err error) (types.Part, error) {
+	if f.conf.FromManifest {
+		return f.errorPart(target, err), nil
+	}
+	if f.conf.OnError != "skip" {
+		return nil, err
+	}
+	f.skipFile(target.path, err)
+	return nil, nil
+}
+
+// cutLeadingLines removes the lines configured to be skipped from the start of
+// the contents of a file. When header metadata is enabled the line following
+// them is also removed and returned as the header.
+func (f *Files) cutLeadingLines(b, delim []byte) (body, header []byte) {
+	cutLine := func(b []byte) (line, rest []byte) {
+		if i := bytes.Index(b, delim); i >= 0 {
+			return b[:i], b[i+len(delim):]
 		}
-		if info.IsDir() {
-			return nil
+		return b, nil
+	}
+	for i := 0; i < f.conf.SkipLeadingLines && len(b) > 0; i++ {
+		_, b = cutLine(b)
+	}
+	if f.conf.HeaderMetadata && len(b) > 0 {
+		header, b = cutLine(b)
+	}
+	return b, header
+}
+
+// readLine reads the next line from the files being streamed, moving onto the
+// next file each time one is exhausted.
+func (f *Files) readLine() (types.Message, error) {
+	for {
+		if msg := f.popEOD(); msg != nil {
+			f.lastEOD = true
+			return msg, nil
 		}
-		f.targets = append(f.targets, path)
-		return nil
-	})
+		msg, err := f.lines.Read()
+		if err == nil {
+			f.unacked = true
+			msg.Iter(func(i int, p types.Part) error {
+				if len(f.conf.MetadataPrefix) > 0 {
+					for _, k := range []string{"line_number", "start_offset", "end_offset"} {
+						if v := p.Metadata().Get(k); len(v) > 0 {
+							p.Metadata().Delete(k)
+							p.Metadata().Set(f.metaKey(k), v)
+						}
+					}
+				}
+				if f.current != nil {
+					f.setMetadata(p, *f.current)
+				}
+				return nil
+			})
+			return msg, nil
+		}
+		if err != types.ErrNotConnected {
+			if f.conf.OnError != "skip" || f.current == nil {
+				return nil, err
+			}
+			// The handle of the failed file is closed and the next read
+			// moves onto the next file.
+			f.skipFile(f.current.path, err)
+			continue
+		}
+
+		if f.current != nil {
+			// All lines of the current file have been read, and therefore
+			// unless we're awaiting an acknowledgement we're done with it.
+			if f.tracksPending() && !f.currentRejected {
+				f.pending = append(f.pending, f.current.path)
+			}
+			f.finishTarget(*f.current)
+			f.current = nil
+			f.currentRejected = false
+			if !f.unacked {
+				if err = f.finishPending(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(f.eods) > 0 {
+			// Directories finished by the current file are marked before
+			// moving onto the next.
+			continue
+		}
+		if err = f.lines.Connect(); err != nil {
+			if f.failed != nil {
+				target := *f.failed
+				f.failed = nil
+				f.finishTarget(target)
+				msg := message.New(nil)
+				msg.Append(f.errorPart(target, err))
+				return msg, nil
+			}
+			return nil, err
+		}
+	}
+}
 
-	return &f, err
+// nextHandle opens the next target file to be streamed line by line.
+func (f *Files) nextHandle() (io.Reader, error) {
+	for {
+		if len(f.targets) == 0 {
+			if !f.conf.Watch {
+				return nil, io.EOF
+			}
+			if err := f.waitForTargets(); err != nil {
+				if err == types.ErrTypeClosed {
+					return nil, io.EOF
+				}
+				return nil, err
+			}
+		}
+
+		target := f.targets[0]
+		f.targets = f.targets[1:]
+
+		err := target.err
+		var handle *fileHandle
+		if err == nil {
+			handle, err = f.openFile(target.path, nil)
+		}
+		if err == nil && f.conf.TailLines > 0 && !handle.tailed {
+			err = f.tailInMemory(handle, target.path)
+		}
+		if err == nil {
+			if f.conf.DetectType {
+				target.contentType = sniffContentType(handle)
+			}
+			if f.conf.Codec != "none" {
+				target.codec = handle.codec
+			}
+			f.current = &target
+			return handle, nil
+		}
+		if f.conf.FromManifest {
+			f.failed = &target
+			return nil, err
+		}
+		if f.conf.OnError != "skip" {
+			return nil, err
+		}
+		f.skipFile(target.path, err)
+		f.finishTarget(target)
+	}
 }
 
-//------------------------------------------------------------------------------
+// skipFile records that a file could not be read and is being skipped.
+func (f *Files) skipFile(path string, err error) {
+	f.mErrors.Incr(1)
+	f.log.Warnf("Skipping file '%v': %v\n", path, err)
+}
 
-// Connect establishes a connection.
-func (f *Files) Connect() (err error) {
+// errorPart creates an empty message part reporting a file of a manifest that
+// could not be read.
+func (f *Files) errorPart(target fileTarget, err error) types.Part {
+	part := message.NewPart(nil)
+	f.setMetadata(part, target)
+	part.Metadata().Set(f.metaKey("read_error"), err.Error())
+	return part
+}
+
+// metaKey returns a metadata key with the configured prefix.
+func (f *Files) metaKey(key string) string {
+	return f.conf.MetadataPrefix + key
+}
+
+func (f *Files) setMetadata(p types.Part, target fileTarget) {
+	target.setMetadata(p, f.conf.MetadataPrefix)
+	if f.conf.RelativePaths && len(target.rel) > 0 {
+		absPath, err := filepath.Abs(target.path)
+		if err != nil {
+			absPath = target.path
+		}
+		p.Metadata().Set(f.metaKey("path"), target.rel)
+		p.Metadata().Set(f.metaKey("absolute_path"), absPath)
+	}
+	if len(target.rel) > 0 {
+		// Only files found by walking have a path relative to their root.
+		depth := strings.Count(filepath.ToSlash(target.rel), "/")
+		p.Metadata().Set(f.metaKey("depth"), strconv.Itoa(depth))
+	}
+	if len(f.conf.Roots) > 0 {
+		p.Metadata().Set(f.metaKey("path_index"), strconv.Itoa(target.root))
+	}
+	if target.index > 0 {
+		p.Metadata().Set(f.metaKey("file_index"), strconv.Itoa(target.index))
+		p.Metadata().Set(f.metaKey("file_total"), strconv.Itoa(f.total))
+	}
+	if len(target.contentType) > 0 {
+		p.Metadata().Set(f.metaKey("content_type"), target.contentType)
+	}
+	if len(target.codec) > 0 {
+		p.Metadata().Set(f.metaKey("codec"), target.codec)
+	}
+	if target.truncated {
+		p.Metadata().Set(f.metaKey("truncated"), "true")
+	}
+}
+
+// setMetadata adds the path of a file and the information gathered about it
+// to the metadata of a message part, with each key prefixed.
+func (t fileTarget) setMetadata(p types.Part, prefix string) {
+	meta := p.Metadata()
+	meta.Set(prefix+"path", t.path)
+	if t.info == nil {
+		return
+	}
+	meta.Set(prefix+"size_bytes", strconv.FormatInt(t.info.Size(), 10))
+	meta.Set(prefix+"mod_time_unix", strconv.FormatInt(t.info.ModTime().Unix(), 10))
+	meta.Set(prefix+"mod_time", t.info.ModTime().Format(time.RFC3339))
+}
+
+// codecExts are the file extensions that identify a codec.
+var codecExts = map[string]string{
+	".gz":   "gzip",
+	".bz2":  "bzip2",
+	".zst":  "zstd",
+	".zstd": "zstd",
+}
+
+var bzip2Magic = []byte("BZh")
+
+// codec returns the codec to decode the contents of a file with. When the
+// codec is auto and the extension of the file is not recognised the codec is
+// detected from the contents of the file, and so auto is returned.
+func (f *Files) codec(path string) string {
+	if f.conf.Codec != "auto" {
+		return f.conf.Codec
+	}
+	if codec, exists := codecExts[strings.ToLower(filepath.Ext(path))]; exists {
+		return codec
+	}
+	return "auto"
+}
+
+// delimFor returns the delimiter used to divide the lines of a file, which is
+// the delimiter configured for its extension if there is one. The extension of
+// a compressed file is taken from its name without the extension of its codec,
+// e.g. the .gz suffix.
+func (f *Files) delimFor(path string) []byte {
+	if f.extDelims == nil {
+		return f.delim
+	}
+	ext := filepath.Ext(path)
+	if codec, exists := codecExts[strings.ToLower(ext)]; exists && f.codec(path) == codec {
+		ext = filepath.Ext(strings.TrimSuffix(path, ext))
+	}
+	if delim, exists := f.extDelims[strings.ToLower(ext)]; exists {
+		return delim
+	}
+	return f.delim
+}
+
+// fileHandle is a decoded file, closing the handle closes both the decoder and
+// the underlying file.
+type fileHandle struct {
+	io.Reader
+	raw     io.Reader
+	closers []io.Closer
+
+	// Whether the file was opened at the start of its tail lines.
+	tailed bool
+
+	// The codec applied to the file.
+	codec string
+}
+
+func (h *fileHandle) Close() error {
+	var err error
+	for i := len(h.closers) - 1; i >= 0; i-- {
+		if cerr := h.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// seekTail moves the read position of a file to the start of its last n lines.
+func seekTail(file *os.File, n int, delim []byte) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	offset, err := tailOffset(file, info.Size(), n, delim)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// tailInMemory reduces the decoded contents of a file that could not be seeked
+// to its last lines by reading the contents in full.
+func (f *Files) tailInMemory(handle *fileHandle, path string) error {
+	contents, err := ioutil.ReadAll(handle.Reader)
+	if err != nil {
+		handle.Close()
+		return fmt.Errorf("failed to read file '%v': %v", path, err)
+	}
+	offset, _ := tailOffset(bytes.NewReader(contents), int64(len(contents)), f.conf.TailLines, f.delimFor(path))
+	handle.Reader = bytes.NewReader(contents[offset:])
 	return nil
 }
 
-//------------------------------------------------------------------------------
+// tailOffset returns the offset of the start of the last n lines of the first
+// size bytes of r, which is found by scanning backwards from the end in chunks.
+// A delimiter at the very end terminates the last line rather than beginning an
+// empty one. If there are fewer than n lines the offset is zero.
+func tailOffset(r io.ReaderAt, size int64, n int, delim []byte) (int64, error) {
+	const chunkSize = 32 * 1024
 
-// Read a new Files message.
-func (f *Files) Read() (types.Message, error) {
-	if len(f.targets) == 0 {
-		return nil, types.ErrTypeClosed
+	end := size
+	if size >= int64(len(delim)) {
+		last := make([]byte, len(delim))
+		if _, err := r.ReadAt(last, size-int64(len(delim))); err != nil {
+			return 0, err
+		}
+		if bytes.Equal(last, delim) {
+			end -= int64(len(delim))
+		}
 	}
 
-	path := f.targets[0]
-	f.targets = f.targets[1:]
+	// The start of the chunk read previously, kept in order to find delimiters
+	// that straddle two chunks.
+	var overlap []byte
 
-	file, openerr := os.Open(path)
-	if openerr != nil {
-		return nil, fmt.Errorf("failed to read file '%v': %v", path, openerr)
+	found := 0
+	for pos := end; pos > 0; {
+		readLen := int64(chunkSize)
+		if readLen > pos {
+			readLen = pos
+		}
+		pos -= readLen
+
+		window := make([]byte, readLen, readLen+int64(len(overlap)))
+		if _, err := r.ReadAt(window, pos); err != nil {
+			return 0, err
+		}
+		window = append(window, overlap...)
+
+		for i := len(window); ; {
+			j := bytes.LastIndex(window[:i], delim)
+			if j < 0 {
+				break
+			}
+			if found++; found == n {
+				return pos + int64(j+len(delim)), nil
+			}
+			i = j
+		}
+
+		overlap = window
+		if len(overlap) > len(delim)-1 {
+			overlap = overlap[:len(delim)-1]
+		}
+	}
+	return 0, nil
+}
+
+// sniffContentType detects the content type of a file from the first 512
+// bytes of its decoded contents. The bytes are buffered rather than consumed,
+// and are therefore still read from the handle afterwards.
+func sniffContentType(h *fileHandle) string {
+	buffered := bufio.NewReaderSize(h.Reader, 512)
+	h.Reader = buffered
+
+	// Errors are ignored here as they are returned by subsequent reads.
+	sniff, _ := buffered.Peek(512)
+	return http.DetectContentType(sniff)
+}
+
+// deadlineReader reads from a special file, failing a read that does not
+// complete within a timeout in order to avoid blocking forever on a stalled
+// writer.
+type deadlineReader struct {
+	file    *os.File
+	timeout time.Duration
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	// Files that do not support deadlines are read without one.
+	d.file.SetReadDeadline(time.Now().Add(d.timeout))
+	return d.file.Read(p)
+}
+
+// openFile opens a file and wraps it in the decoder of its codec. When a tee
+// writer is provided the raw contents of the file are written to it as they are
+// read.
+func (f *Files) openFile(path string, tee io.Writer) (*fileHandle, error) {
+	var file *os.File
+	var err error
+	special := false
+	if f.conf.SpecialFiles == "read" {
+		var info os.FileInfo
+		if info, err = os.Stat(path); err == nil && !info.Mode().IsRegular() {
+			special = true
+		}
+	}
+	if special {
+		file, err = openSpecialFile(path)
+	} else {
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file '%v': %v", path, err)
+	}
+
+	tailed := false
+	if f.conf.TailLines > 0 && !special && tee == nil && f.codec(path) == "none" {
+		if err = seekTail(file, f.conf.TailLines, f.delimFor(path)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read file '%v': %v", path, err)
+		}
+		tailed = true
+	}
+
+	var raw io.Reader = file
+	if special {
+		raw = &deadlineReader{file: file, timeout: f.specialTimeout}
+	}
+	if tee != nil {
+		raw = io.TeeReader(raw, tee)
+	}
+	handle := &fileHandle{
+		Reader:  raw,
+		raw:     raw,
+		closers: []io.Closer{file},
+		tailed:  tailed,
+		codec:   f.codec(path),
+	}
+	if handle.codec == "auto" {
+		buffered := bufio.NewReader(raw)
+		handle.Reader = buffered
+		magic, _ := buffered.Peek(len(zstdMagic))
+		switch {
+		case bytes.HasPrefix(magic, gzipMagic):
+			handle.codec = "gzip"
+		case bytes.HasPrefix(magic, bzip2Magic):
+			handle.codec = "bzip2"
+		case bytes.HasPrefix(magic, zstdMagic):
+			handle.codec = "zstd"
+		default:
+			// Unrecognised files are read raw.
+			handle.codec = "none"
+		}
+	}
+	switch handle.codec {
+	case "gzip":
+		gzRdr, err := gzip.NewReader(handle.Reader)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to decompress file '%v': %v", path, err)
+		}
+		handle.Reader = gzRdr
+		handle.closers = append(handle.closers, gzRdr)
+	case "bzip2":
+		handle.Reader = bzip2.NewReader(handle.Reader)
+	case "zstd":
+		zstdRdr := zstd.NewReader(handle.Reader)
+		handle.Reader = zstdRdr
+		handle.closers = append(handle.closers, zstdRdr)
+	}
+	return handle, nil
+}
+
+// readFile reads and decodes the full contents of the file of a target, which
+// is given the codec that was applied when a codec is configured, and is marked
+// as truncated when only the head of the file was read. When a hash is
+// configured the hex encoded digest of the raw file contents is also returned.
+func (f *Files) readFile(target *fileTarget) ([]byte, string, error) {
+	path := target.path
+	var h hash.Hash
+	var tee io.Writer
+	if f.newHash != nil {
+		h = f.newHash()
+		tee = h
+	}
+
+	handle, err := f.openFile(path, tee)
+	if err != nil {
+		return nil, "", err
+	}
+	defer handle.Close()
+	if f.conf.Codec != "none" {
+		target.codec = handle.codec
 	}
-	defer file.Close()
 
-	msgBytes, readerr := ioutil.ReadAll(file)
-	if readerr != nil {
-		return nil, readerr
+	// One byte beyond each limit is read so that we know whether a file
+	// exceeds it. A file is never rejected for its size when only a smaller
+	// head of it is read.
+	var src io.Reader = handle
+	if f.conf.HeadBytes > 0 && (f.conf.MaxFileSize == 0 || f.conf.HeadBytes < f.conf.MaxFileSize) {
+		src = io.LimitReader(handle, f.conf.HeadBytes+1)
+	} else if f.conf.MaxFileSize > 0 {
+		src = io.LimitReader(handle, f.conf.MaxFileSize+1)
+	}
+	var buf bytes.Buffer
+	// The buffer and reader are wrapped so that the copy is made in chunks of
+	// our configured size rather than by their own ReadFrom and WriteTo.
+	if _, err = io.CopyBuffer(
+		struct{ io.Writer }{&buf},
+		struct{ io.Reader }{src},
+		make([]byte, f.conf.ReadChunkSize),
+	); err != nil {
+		return nil, "", fmt.Errorf("failed to read file '%v': %v", path, err)
+	}
+	if f.conf.MaxFileSize > 0 && int64(buf.Len()) > f.conf.MaxFileSize {
+		return nil, "", fmt.Errorf("file '%v' exceeds the max file size of %v bytes", path, f.conf.MaxFileSize)
+	}
+	msgBytes := buf.Bytes()
+	if f.conf.HeadBytes > 0 && int64(len(msgBytes)) > f.conf.HeadBytes {
+		msgBytes = msgBytes[:f.conf.HeadBytes]
+		target.truncated = true
+	}
+	if f.conf.TailLines > 0 && !handle.tailed {
+		offset, _ := tailOffset(bytes.NewReader(msgBytes), int64(len(msgBytes)), f.conf.TailLines, f.delimFor(path))
+		msgBytes = msgBytes[offset:]
+	}
+	if h == nil {
+		return msgBytes, "", nil
 	}
 
-	msg := message.New([][]byte{msgBytes})
-	msg.Get(0).Metadata().Set("path", path)
-	return msg, nil
+	// A decoder might not consume trailing bytes of the file.
+	if _, err = io.Copy(ioutil.Discard, handle.raw); err != nil {
+		return nil, "", fmt.Errorf("failed to read file '%v': %v", path, err)
+	}
+	return msgBytes, hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // Acknowledge instructs whether unacknowledged messages have been successfully
 // propagated.
 func (f *Files) Acknowledge(err error) error {
+	if f.lines != nil && !f.lastEOD {
+		f.lines.Acknowledge(err)
+	}
+	if err != nil {
+		// Files remain pending until they are successfully acknowledged.
+		if f.onAck != nil {
+			for _, path := range f.pending {
+				f.onAck(path, err)
+			}
+		}
+		if !f.conf.RetryFailed {
+			// Rejected messages are not resent, and so their files are
+			// never finished.
+			f.pending = nil
+			f.currentRejected = f.current != nil
+			f.unacked = false
+		}
+		return nil
+	}
+	f.unacked = false
+	return f.finishPending()
+}
+
+// tracksPending returns whether files that have been read are kept until they
+// are acknowledged.
+func (f *Files) tracksPending() bool {
+	return f.conf.DeleteOnFinish || len(f.conf.Checkpoint) > 0 || f.onAck != nil
+}
+
+// finishPending completes all files that have been fully read and
+// acknowledged by deleting them and recording the last of them as the
+// checkpoint, depending on the configuration.
+func (f *Files) finishPending() error {
+	pending := f.pending
+	f.pending = nil
+	if f.onAck != nil {
+		for _, path := range pending {
+			f.onAck(path, nil)
+		}
+	}
+	if f.conf.DeleteOnFinish {
+		for i, path := range pending {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				f.pending = append(f.pending, pending[i+1:]...)
+				return fmt.Errorf("failed to delete file '%v': %v", path, err)
+			}
+		}
+	}
+	if len(f.conf.Checkpoint) == 0 || len(pending) == 0 {
+		return nil
+	}
+	if f.completed == nil {
+		return f.writeCheckpoint(pending[len(pending)-1])
+	}
+	if checkpoint := f.completeDispatched(pending); len(checkpoint) > 0 {
+		return f.writeCheckpoint(checkpoint)
+	}
+	return nil
+}
+
+// completeDispatched marks paths read concurrently as completed and returns
+// the last path of the longest run of completed files, in the order that they
+// began being read, or an empty string if the earliest file is still
+// outstanding.
+func (f *Files) completeDispatched(paths []string) string {
+	for _, path := range paths {
+		f.completed[path]++
+	}
+	var last string
+	for len(f.dispatched) > 0 {
+		path := f.dispatched[0]
+		if f.completed[path] == 0 {
+			break
+		}
+		if f.completed[path]--; f.completed[path] == 0 {
+			delete(f.completed, path)
+		}
+		f.dispatched = f.dispatched[1:]
+		last = path
+	}
+	return last
+}
+
+// writeCheckpoint replaces the checkpoint file with a path.
+func (f *Files) writeCheckpoint(path string) error {
+	tmpPath := f.conf.Checkpoint + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte(path), 0666); err != nil {
+		return fmt.Errorf("failed to write checkpoint '%v': %v", f.conf.Checkpoint, err)
+	}
+	if err := os.Rename(tmpPath, f.conf.Checkpoint); err != nil {
+		return fmt.Errorf("failed to write checkpoint '%v': %v", f.conf.Checkpoint, err)
+	}
 	return nil
 }
 
 // CloseAsync shuts down the Files input and stops processing requests.
 func (f *Files) CloseAsync() {
+	f.closeOnce.Do(func() {
+		close(f.closeChan)
+	})
+	if f.lines != nil {
+		f.lines.CloseAsync()
+	}
 }
 
 // WaitForClose blocks until the Files input has closed down.
 func (f *Files) WaitForClose(timeout time.Duration) error {
+	if f.archive != nil {
+		f.closeArchive()
+	}
+	if f.lines != nil {
+		return f.lines.WaitForClose(timeout)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// archiveFor returns the archive format of a file, or "none" if the file is
+// to be read as a whole.
+func (f *Files) archiveFor(path string) string {
+	if f.conf.Archive != "auto" {
+		return f.conf.Archive
+	}
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"),
+		strings.HasSuffix(lower, ".tar.gz"),
+		strings.HasSuffix(lower, ".tgz"):
+		return "tar"
+	}
+	return "none"
+}
+
+// archiveEntry is a regular file read from an archive.
+type archiveEntry struct {
+	name string
+	size int64
+	body []byte
+}
+
+// archiveEntries iterates the regular file entries of an archive, returning
+// io.EOF once they are exhausted.
+type archiveEntries interface {
+	next() (*archiveEntry, error)
+	Close() error
+}
+
+type tarEntries struct {
+	r      *tar.Reader
+	handle *fileHandle
+}
+
+func (t *tarEntries) next() (*archiveEntry, error) {
+	for {
+		hdr, err := t.r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		body, err := ioutil.ReadAll(t.r)
+		if err != nil {
+			return nil, err
+		}
+		return &archiveEntry{name: hdr.Name, size: hdr.Size, body: body}, nil
+	}
+}
+
+func (t *tarEntries) Close() error {
+	return t.handle.Close()
+}
+
+type zipEntries struct {
+	r     *zip.ReadCloser
+	files []*zip.File
+}
+
+func (z *zipEntries) next() (*archiveEntry, error) {
+	for len(z.files) > 0 {
+		file := z.files[0]
+		z.files = z.files[1:]
+		if !file.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return &archiveEntry{name: file.Name, size: int64(file.UncompressedSize64), body: body}, nil
+	}
+	return nil, io.EOF
+}
+
+func (z *zipEntries) Close() error {
+	return z.r.Close()
+}
+
+// openArchive opens a target as an archive and reads ahead its first entry. An
+// archive without any entries is consumed immediately.
+func (f *Files) openArchive(target fileTarget) error {
+	if target.err != nil {
+		return target.err
+	}
+
+	var entries archiveEntries
+	switch f.archiveFor(target.path) {
+	case "zip":
+		r, err := zip.OpenReader(target.path)
+		if err != nil {
+			return fmt.Errorf("failed to read archive '%v': %v", target.path, err)
+		}
+		entries = &zipEntries{r: r, files: r.File}
+	default:
+		handle, err := f.openFile(target.path, nil)
+		if err != nil {
+			return err
+		}
+		// Tarballs are commonly gzipped regardless of the codec.
+		buffered := bufio.NewReader(handle.Reader)
+		handle.Reader = buffered
+		if magic, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+			gzRdr, err := gzip.NewReader(buffered)
+			if err != nil {
+				handle.Close()
+				return fmt.Errorf("failed to decompress file '%v': %v", target.path, err)
+			}
+			handle.Reader = gzRdr
+			handle.closers = append(handle.closers, gzRdr)
+		}
+		entries = &tarEntries{r: tar.NewReader(handle), handle: handle}
+	}
+
+	next, err := entries.next()
+	if err != nil {
+		entries.Close()
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("failed to read archive '%v': %v", target.path, err)
+	}
+	f.archive, f.archiveTarget, f.archiveNext = entries, target, next
 	return nil
 }
 
+// readArchiveEntry reads the next entry of the open archive into a message
+// part, reading ahead in order to find out whether it is the last entry. Once
+// the last entry is read the archive becomes pending. An error reading ahead
+// is held back until the following call, where the on_error strategy applies.
+func (f *Files) readArchiveEntry() (types.Part, error) {
+	target := f.archiveTarget
+	if err := f.archiveErr; err != nil {
+		f.archiveErr = nil
+		return f.failedPart(target, err)
+	}
+
+	entry := f.archiveNext
+	next, err := f.archive.next()
+	if err != nil {
+		f.closeArchive()
+		if err != io.EOF {
+			f.archiveErr = fmt.Errorf("failed to read archive '%v': %v", target.path, err)
+		} else if f.tracksPending() {
+			f.pending = append(f.pending, target.path)
+		}
+	} else {
+		f.archiveNext = next
+	}
+
+	var digest string
+	if f.newHash != nil {
+		h := f.newHash()
+		h.Write(entry.body)
+		digest = hex.EncodeToString(h.Sum(nil))
+	}
+	part := f.contentPart(target, f.delimFor(entry.name), entry.body, digest)
+	part.Metadata().Set(f.metaKey("entry_name"), entry.name)
+	part.Metadata().Set(f.metaKey("entry_size"), strconv.FormatInt(entry.size, 10))
+	return part, nil
+}
+
+// closeArchive closes the archive currently being read.
+func (f *Files) closeArchive() {
+	f.archive.Close()
+	f.archive, f.archiveNext = nil, nil
+}
+
 //------------------------------------------------------------------------------
@@ -0,0 +1,176 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// +build !windows,!wasm
+
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func setupSpecialFiles(t *testing.T) (string, string) {
+	t.Helper()
+
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo",
+	})
+	fifoPath := filepath.Join(tmpDir, "b")
+	if err = syscall.Mkfifo(fifoPath, 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		t.Skipf("Unable to create named pipe: %v", err)
+	}
+	return tmpDir, fifoPath
+}
+
+func TestFilesSpecialFilesSkip(t *testing.T) {
+	tmpDir, _ := setupSpecialFiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		filepath.Join(tmpDir, "a"): "foo",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestFilesSpecialFilesError(t *testing.T) {
+	tmpDir, _ := setupSpecialFiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "name"
+	conf.SpecialFiles = "error"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestFilesSpecialFilesRead(t *testing.T) {
+	tmpDir, fifoPath := setupSpecialFiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	conf := NewFilesConfig()
+	conf.Path = fifoPath
+	conf.SpecialFiles = "read"
+	conf.SpecialTimeout = "1s"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		w, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Write([]byte("bar"))
+		w.Close()
+	}()
+
+	// Wait for the writer to open the pipe, as otherwise the read sees no
+	// writer and ends immediately.
+	<-time.After(time.Millisecond * 50)
+
+	msg, err := f.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "bar", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong content: %v != %v", act, exp)
+	}
+	if _, err = f.Read(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestFilesSpecialFilesReadTimeout(t *testing.T) {
+	tmpDir, fifoPath := setupSpecialFiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	conf := NewFilesConfig()
+	conf.Path = fifoPath
+	conf.SpecialFiles = "read"
+	conf.SpecialTimeout = "50ms"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open the pipe for writing without ever writing to it.
+	w, err := os.OpenFile(fifoPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err = f.Read(); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Expected timeout error from stalled writer: %v", err)
+	}
+}
+
+//------------------------------------------------------------------------------
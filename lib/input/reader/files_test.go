@@ -21,11 +21,23 @@
 package reader
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
 	"github.com/Jeffail/benthos/v3/lib/types"
 )
 
@@ -74,7 +86,7 @@ func TestFilesDirectory(t *testing.T) {
 	conf.Path = tmpDir
 
 	var f Type
-	if f, err = NewFiles(conf); err != nil {
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
 		t.Fatal(err)
 	}
 
@@ -132,7 +144,7 @@ func TestFilesFile(t *testing.T) {
 	conf.Path = tmpFile.Name()
 
 	var f Type
-	if f, err = NewFiles(conf); err != nil {
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
 		t.Fatal(err)
 	}
 
@@ -163,9 +175,2866 @@ func TestFilesBadPath(t *testing.T) {
 	conf := NewFilesConfig()
 	conf.Path = "fdgdfkte34%#@$%#$%KL@#K$@:L#$23k;32l;23"
 
-	if _, err := NewFiles(conf); err == nil {
+	if _, err := NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
 		t.Error("Expected error from bad path")
 	}
 }
 
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readAllFiles(t *testing.T, f Type) map[string]string {
+	t.Helper()
+
+	if err := f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	act := map[string]string{}
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := msg.Get(0).Metadata().Get("path")
+		if _, exists := act[path]; exists {
+			t.Errorf("Received duplicate message: %v", path)
+		}
+		act[path] = string(msg.Get(0).Get())
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	return act
+}
+
+func TestFilesGlobs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.log":          "a",
+		"b.tmp":          "b",
+		"sub/c.log":      "c",
+		"sub/d.log.tmp":  "d",
+		"sub/deep/e.log": "e",
+	})
+
+	tests := []struct {
+		include string
+		exclude string
+		exp     []string
+	}{
+		{"", "", []string{"a.log", "b.tmp", "sub/c.log", "sub/d.log.tmp", "sub/deep/e.log"}},
+		{"*.log", "", []string{"a.log"}},
+		{"**/*.log", "", []string{"a.log", "sub/c.log", "sub/deep/e.log"}},
+		{"sub/**", "**/*.tmp", []string{"sub/c.log", "sub/deep/e.log"}},
+		{"", "**/*.tmp", []string{"a.log", "sub/c.log", "sub/deep/e.log"}},
+	}
+
+	for _, test := range tests {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Include = test.include
+		conf.Exclude = test.exclude
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := map[string]string{}
+		for _, p := range test.exp {
+			exp[filepath.Join(tmpDir, p)] = filepath.Base(p)[:1]
+		}
+		if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for '%v' '%v': %v != %v", test.include, test.exclude, act, exp)
+		}
+	}
+}
+
+func TestFilesBadGlob(t *testing.T) {
+	conf := NewFilesConfig()
+	conf.Path = os.TempDir()
+	conf.Include = "[foo"
+
+	if _, err := NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad pattern")
+	}
+}
+
+func TestFilesNotRecursive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":         "a",
+		"b":         "b",
+		"sub/c":     "c",
+		"sub/sub/d": "d",
+	})
+	if err = os.Symlink(filepath.Join(tmpDir, "sub"), filepath.Join(tmpDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Recursive = false
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		filepath.Join(tmpDir, "a"): "a",
+		filepath.Join(tmpDir, "b"): "b",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestFilesMetadata(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write([]byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Unix(1567000000, 0)
+	if err = os.Chtimes(tmpFile.Name(), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = tmpFile.Name()
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := f.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"path":          tmpFile.Name(),
+		"size_bytes":    "3",
+		"mod_time_unix": "1567000000",
+		"mod_time":      modTime.Format(time.RFC3339),
+		"depth":         "0",
+	}
+	act := map[string]string{}
+	msg.Get(0).Metadata().Iter(func(k, v string) error {
+		act[k] = v
+		return nil
+	})
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong metadata: %v != %v", act, exp)
+	}
+}
+
+func TestFilesSort(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":     "333",
+		"b":     "1",
+		"sub/c": "22",
+	})
+	for i, name := range []string{"sub/c", "a", "b"} {
+		modTime := time.Unix(int64(1567000000+i), 0)
+		if err = os.Chtimes(filepath.Join(tmpDir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string][]string{
+		"name":     {"333", "1", "22"},
+		"mod_time": {"22", "333", "1"},
+		"size":     {"1", "22", "333"},
+	}
+
+	for sortBy, exp := range tests {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Sort = sortBy
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var act []string
+		for {
+			msg, err := f.Read()
+			if err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			act = append(act, string(msg.Get(0).Get()))
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for sort '%v': %v != %v", sortBy, act, exp)
+		}
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "nope"
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad sort")
+	}
+}
+
+func TestFilesDeleteOnFinish(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "a",
+		"b": "b",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.DeleteOnFinish = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = f.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Acknowledge(errors.New("nope")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(tmpDir, "a")); err != nil {
+		t.Errorf("Expected file to remain after failed ack: %v", err)
+	}
+
+	if _, err = f.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err = os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected file '%v' to be deleted: %v", name, err)
+		}
+	}
+}
+
+func TestFilesCodec(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gzBuf bytes.Buffer
+	gzWtr := gzip.NewWriter(&gzBuf)
+	gzWtr.Write([]byte("compressed"))
+	gzWtr.Close()
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.gz":  gzBuf.String(),
+		"b.gz":  "not actually compressed",
+		"c.txt": "plain",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Codec = "auto"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := f.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "compressed", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	if _, err = f.Read(); err == nil {
+		t.Error("Expected error from corrupt file")
+	}
+
+	if msg, err = f.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "plain", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	if _, err = f.Read(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestFilesLineDelimited(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo\n\nbar\n",
+		"b": "baz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.LineDelimited = true
+	conf.DeleteOnFinish = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		f.CloseAsync()
+		if err := f.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	type line struct {
+		content, path, lineNumber string
+	}
+	exp := []line{
+		{"foo", filepath.Join(tmpDir, "a"), "1"},
+		{"bar", filepath.Join(tmpDir, "a"), "3"},
+		{"baz", filepath.Join(tmpDir, "b"), "1"},
+	}
+
+	var act []line
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, line{
+			content:    string(msg.Get(0).Get()),
+			path:       msg.Get(0).Metadata().Get("path"),
+			lineNumber: msg.Get(0).Metadata().Get("line_number"),
+		})
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if _, err = os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected file '%v' to be deleted: %v", name, err)
+		}
+	}
+}
+
+func TestFilesLeadingLines(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "# comment\nname,age\nfoo,10\nbar,20\n",
+		"b": "",
+		"c": "# comment",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "name"
+	conf.SkipLeadingLines = 1
+	conf.HeaderMetadata = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		content, header string
+	}
+	exp := []result{
+		{"foo,10\nbar,20\n", "name,age"},
+		{"", ""},
+		{"", ""},
+	}
+
+	var act []result
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, result{
+			content: string(msg.Get(0).Get()),
+			header:  msg.Get(0).Metadata().Get("header"),
+		})
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestFilesFromManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo",
+		"b": "bar",
+		"manifest": strings.Join([]string{
+			filepath.Join(tmpDir, "b"),
+			filepath.Join(tmpDir, "missing"),
+			"",
+			filepath.Join(tmpDir, "a"),
+		}, "\n"),
+	})
+
+	type result struct {
+		content, path string
+		failed        bool
+	}
+	exp := []result{
+		{"bar", filepath.Join(tmpDir, "b"), false},
+		{"", filepath.Join(tmpDir, "missing"), true},
+		{"foo", filepath.Join(tmpDir, "a"), false},
+	}
+
+	for _, lineDelimited := range []bool{false, true} {
+		conf := NewFilesConfig()
+		conf.Path = filepath.Join(tmpDir, "manifest")
+		conf.FromManifest = true
+		conf.LineDelimited = lineDelimited
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var act []result
+		for {
+			msg, err := f.Read()
+			if err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			act = append(act, result{
+				content: string(msg.Get(0).Get()),
+				path:    msg.Get(0).Metadata().Get("path"),
+				failed:  len(msg.Get(0).Metadata().Get("read_error")) > 0,
+			})
+			if err = f.Acknowledge(nil); err != nil {
+				t.Error(err)
+			}
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result with line_delimited %v: %v != %v", lineDelimited, act, exp)
+		}
+	}
+}
+
+func TestFilesOnErrorSkip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gzBuf bytes.Buffer
+	gzWtr := gzip.NewWriter(&gzBuf)
+	gzWtr.Write([]byte("compressed"))
+	gzWtr.Close()
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.gz": gzBuf.String(),
+		"b.gz": "not actually compressed",
+		"c.gz": "also not compressed",
+		"d.gz": gzBuf.String(),
+	})
+
+	for _, lineDelimited := range []bool{false, true} {
+		stats := metrics.NewLocal()
+
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Sort = "name"
+		conf.Codec = "gzip"
+		conf.OnError = "skip"
+		conf.LineDelimited = lineDelimited
+
+		f, err := NewFiles(conf, log.Noop(), stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := map[string]string{
+			filepath.Join(tmpDir, "a.gz"): "compressed",
+			filepath.Join(tmpDir, "d.gz"): "compressed",
+		}
+		if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result with line_delimited %v: %v != %v", lineDelimited, act, exp)
+		}
+		if exp, act := int64(2), stats.GetCounters()["files.errors"]; exp != act {
+			t.Errorf("Wrong count of errors with line_delimited %v: %v != %v", lineDelimited, act, exp)
+		}
+	}
+}
+
+func TestFilesBadOnError(t *testing.T) {
+	conf := NewFilesConfig()
+	conf.OnError = "nope"
+	if _, err := NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad on_error")
+	}
+}
+
+func TestFilesCheckpoint(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	checkpointDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(checkpointDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo",
+		"b": "bar",
+		"c": "baz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "name"
+	conf.Checkpoint = filepath.Join(checkpointDir, "checkpoint")
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second file is never acknowledged.
+	if _, err = f.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := ioutil.ReadFile(conf.Checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := filepath.Join(tmpDir, "a"), string(checkpoint); exp != act {
+		t.Errorf("Wrong checkpoint: %v != %v", act, exp)
+	}
+
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		filepath.Join(tmpDir, "b"): "bar",
+		filepath.Join(tmpDir, "c"): "baz",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	if checkpoint, err = ioutil.ReadFile(conf.Checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := filepath.Join(tmpDir, "c"), string(checkpoint); exp != act {
+		t.Errorf("Wrong checkpoint: %v != %v", act, exp)
+	}
+}
+
+func TestFilesCheckpointSortSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	checkpointDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(checkpointDir)
+
+	// Files are read in the order b, c, a.
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "fooba",
+		"b": "f",
+		"c": "foo",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "size"
+	conf.Checkpoint = filepath.Join(checkpointDir, "checkpoint")
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := f.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "f", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	if err = f.Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connecting again does not apply the checkpoint again.
+	exp := map[string]string{
+		filepath.Join(tmpDir, "c"): "foo",
+		filepath.Join(tmpDir, "a"): "fooba",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	if err = ioutil.WriteFile(conf.Checkpoint, []byte(filepath.Join(tmpDir, "c")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+	exp = map[string]string{
+		filepath.Join(tmpDir, "a"): "fooba",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestFilesHash(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "hello world",
+		"b": "",
+	})
+
+	tests := map[string]map[string]string{
+		"md5": {
+			"a": "5eb63bbbe01eeed093cb22bb8f5acdc3",
+			"b": "d41d8cd98f00b204e9800998ecf8427e",
+		},
+		"sha1": {
+			"a": "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed",
+			"b": "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		},
+		"sha256": {
+			"a": "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+			"b": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+
+	for algorithm, exp := range tests {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Hash = algorithm
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		act := map[string]string{}
+		for {
+			msg, err := f.Read()
+			if err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := msg.Get(0).Metadata()
+			if alg := meta.Get("hash_algorithm"); alg != algorithm {
+				t.Errorf("Wrong hash algorithm: %v != %v", alg, algorithm)
+			}
+			act[filepath.Base(meta.Get("path"))] = meta.Get("hash")
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong hashes for '%v': %v != %v", algorithm, act, exp)
+		}
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Hash = "nope"
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad hash algorithm")
+	}
+}
+
+func TestFilesMetadataPrefix(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo\nbar",
+	})
+
+	for _, lineDelimited := range []bool{false, true} {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.MetadataPrefix = "files_"
+		conf.LineDelimited = lineDelimited
+		conf.Hash = "md5"
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := f.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := map[string]bool{}
+		msg.Get(0).Metadata().Iter(func(k, v string) error {
+			keys[k] = true
+			return nil
+		})
+		exp := map[string]bool{
+			"files_path":          true,
+			"files_size_bytes":    true,
+			"files_mod_time_unix": true,
+			"files_mod_time":      true,
+			"files_depth":         true,
+		}
+		if lineDelimited {
+			exp["files_line_number"] = true
+			exp["files_start_offset"] = true
+			exp["files_end_offset"] = true
+		} else {
+			exp["files_hash"] = true
+			exp["files_hash_algorithm"] = true
+		}
+		if !reflect.DeepEqual(keys, exp) {
+			t.Errorf("Wrong metadata keys with line_delimited %v: %v != %v", lineDelimited, keys, exp)
+		}
+	}
+}
+
+func TestFilesWatch(t *testing.T) {
+	for _, lineDelimited := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		writeTestFiles(t, tmpDir, map[string]string{
+			"a": "foo",
+		})
+
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Watch = true
+		conf.PollInterval = "10ms"
+		conf.LineDelimited = lineDelimited
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		readPath := func() string {
+			t.Helper()
+			msg, err := f.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = f.Acknowledge(nil); err != nil {
+				t.Error(err)
+			}
+			return filepath.Base(msg.Get(0).Metadata().Get("path"))
+		}
+
+		if exp, act := "a", readPath(); exp != act {
+			t.Errorf("Wrong file read: %v != %v", act, exp)
+		}
+
+		go func() {
+			<-time.After(time.Millisecond * 50)
+			writeTestFiles(t, tmpDir, map[string]string{
+				"b": "bar",
+			})
+		}()
+		if exp, act := "b", readPath(); exp != act {
+			t.Errorf("Wrong file read: %v != %v", act, exp)
+		}
+
+		go func() {
+			<-time.After(time.Millisecond * 50)
+			f.CloseAsync()
+		}()
+		for {
+			if _, err = f.Read(); err == types.ErrTypeClosed {
+				break
+			} else if err != types.ErrNotConnected {
+				t.Fatalf("Wrong error returned: %v", err)
+			}
+		}
+		if err = f.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestFilesBadPollInterval(t *testing.T) {
+	conf := NewFilesConfig()
+	conf.Watch = true
+	conf.PollInterval = "nope"
+	if _, err := NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad poll interval")
+	}
+}
+
+func TestFilesGroupByDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":     "foo",
+		"b/c":   "bar",
+		"b/d":   "baz",
+		"b/e/f": "qux",
+	})
+	if err = os.MkdirAll(filepath.Join(tmpDir, "g"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.GroupByDir = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	type part struct {
+		content, path, dir string
+	}
+	exp := [][]part{
+		{{"foo", filepath.Join(tmpDir, "a"), tmpDir}},
+		{
+			{"bar", filepath.Join(tmpDir, "b", "c"), filepath.Join(tmpDir, "b")},
+			{"baz", filepath.Join(tmpDir, "b", "d"), filepath.Join(tmpDir, "b")},
+		},
+		{{"qux", filepath.Join(tmpDir, "b", "e", "f"), filepath.Join(tmpDir, "b", "e")}},
+	}
+	for i, expParts := range exp {
+		msg, err := f.Read()
+		if err != nil {
+			t.Fatalf("Message %v: %v", i, err)
+		}
+		var act []part
+		msg.Iter(func(_ int, p types.Part) error {
+			act = append(act, part{
+				content: string(p.Get()),
+				path:    p.Metadata().Get("path"),
+				dir:     p.Metadata().Get("dir"),
+			})
+			return nil
+		})
+		if !reflect.DeepEqual(act, expParts) {
+			t.Errorf("Wrong message %v: %v != %v", i, act, expParts)
+		}
+	}
+	if _, err = f.Read(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestFilesGroupByDirLineDelimited(t *testing.T) {
+	conf := NewFilesConfig()
+	conf.GroupByDir = true
+	conf.LineDelimited = true
+	if _, err := NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from group_by_dir with line_delimited")
+	}
+}
+
+func TestFilesConfigValidate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.txt":  "foo",
+		"b.json": "bar",
+		"manifest": filepath.Join(tmpDir, "a.txt") + "\n" +
+			filepath.Join(tmpDir, "c.txt") + "\n",
+	})
+	if err = os.MkdirAll(filepath.Join(tmpDir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		conf   func(c *FilesConfig)
+		errStr string
+	}{
+		"dir": {
+			conf: func(c *FilesConfig) {},
+		},
+		"single file": {
+			conf: func(c *FilesConfig) {
+				c.Path = filepath.Join(tmpDir, "a.txt")
+			},
+		},
+		"include": {
+			conf: func(c *FilesConfig) {
+				c.Include = "*.json"
+			},
+		},
+		"no match": {
+			conf: func(c *FilesConfig) {
+				c.Include = "*.csv"
+			},
+			errStr: "no files found",
+		},
+		"bad glob": {
+			conf: func(c *FilesConfig) {
+				c.Include = "[a-"
+			},
+			errStr: "failed to parse include pattern",
+		},
+		"missing path": {
+			conf: func(c *FilesConfig) {
+				c.Path = filepath.Join(tmpDir, "nope")
+			},
+			errStr: "failed to find files",
+		},
+		"empty dir": {
+			conf: func(c *FilesConfig) {
+				c.Path = filepath.Join(tmpDir, "empty")
+			},
+			errStr: "no files found",
+		},
+		"manifest missing file": {
+			conf: func(c *FilesConfig) {
+				c.Path = filepath.Join(tmpDir, "manifest")
+				c.FromManifest = true
+			},
+			errStr: "c.txt",
+		},
+	}
+
+	for name, test := range tests {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		test.conf(&conf)
+
+		err := conf.Validate()
+		if len(test.errStr) == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", name, err)
+			}
+		} else if err == nil {
+			t.Errorf("%v: expected error", name)
+		} else if !strings.Contains(err.Error(), test.errStr) {
+			t.Errorf("%v: wrong error: %v", name, err)
+		}
+	}
+}
+
+func TestFilesRoots(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"b/a.txt":     "foo",
+		"b/b.json":    "bar",
+		"a/c.txt":     "baz",
+		"a/sub/d.txt": "qux",
+		"e.txt":       "quz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Roots = []string{
+		filepath.Join(tmpDir, "b"),
+		filepath.Join(tmpDir, "a"),
+		filepath.Join(tmpDir, "e.txt"),
+	}
+	conf.Include = "*.txt"
+	conf.Sort = "name"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	type file struct {
+		content, pathIndex string
+	}
+	var act []file
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, file{
+			content:   string(msg.Get(0).Get()),
+			pathIndex: msg.Get(0).Metadata().Get("path_index"),
+		})
+	}
+
+	// The include pattern is relative to each root, and so the nested file is
+	// excluded.
+	exp := []file{{"foo", "0"}, {"baz", "1"}, {"quz", "2"}}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong files: %v != %v", act, exp)
+	}
+}
+
+func TestFilesRootsWithPath(t *testing.T) {
+	conf := NewFilesConfig()
+	conf.Path = "foo"
+	conf.Roots = []string{"bar"}
+	if _, err := NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from both path and roots")
+	}
+}
+
+func TestFilesSkipEmpty(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":     "foo",
+		"b":     "",
+		"c/d":   "",
+		"c/e":   "bar",
+		"f.txt": "",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.SkipEmpty = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		filepath.Join(tmpDir, "a"):   "foo",
+		filepath.Join(tmpDir, "c/e"): "bar",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestFilesSkipEmptyWatch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.SkipEmpty = true
+	conf.Watch = true
+	conf.PollInterval = "10ms"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.CloseAsync()
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		<-time.After(time.Millisecond * 50)
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, "a"), []byte("foo"), 0644); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	msg, err := f.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong content: %v != %v", act, exp)
+	}
+}
+
+func TestFilesRelativePaths(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":     "foo",
+		"b/c":   "bar",
+		"d.txt": "baz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Roots = []string{tmpDir, filepath.Join(tmpDir, "d.txt")}
+	conf.Exclude = "d.txt"
+	conf.Sort = "name"
+	conf.RelativePaths = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := [][2]string{
+		{"a", filepath.Join(tmpDir, "a")},
+		{filepath.Join("b", "c"), filepath.Join(tmpDir, "b", "c")},
+		{"d.txt", filepath.Join(tmpDir, "d.txt")},
+	}
+	var act [][2]string
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, [2]string{
+			msg.Get(0).Metadata().Get("path"),
+			msg.Get(0).Metadata().Get("absolute_path"),
+		})
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong paths: %v != %v", act, exp)
+	}
+}
+
+func TestFilesBadSpecialFiles(t *testing.T) {
+	conf := NewFilesConfig()
+	conf.SpecialFiles = "nope"
+	if _, err := NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad special files strategy")
+	}
+}
+
+func TestFilesCountFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo",
+		"b": "bar",
+		"c": "baz",
+	})
+	checkpoint := filepath.Join(tmpDir, "checkpoint")
+	if err = ioutil.WriteFile(checkpoint, []byte(filepath.Join(tmpDir, "a")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lineDelimited := range []bool{false, true} {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Include = "[abc]"
+		conf.Sort = "name"
+		conf.Checkpoint = checkpoint
+		conf.CountFiles = true
+		conf.LineDelimited = lineDelimited
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Files skipped due to the checkpoint are not counted.
+		for _, exp := range [][3]string{
+			{"bar", "1", "2"},
+			{"baz", "2", "2"},
+		} {
+			msg, err := f.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			act := [3]string{
+				string(msg.Get(0).Get()),
+				msg.Get(0).Metadata().Get("file_index"),
+				msg.Get(0).Metadata().Get("file_total"),
+			}
+			if act != exp {
+				t.Errorf("Wrong result with line_delimited %v: %v != %v", lineDelimited, act, exp)
+			}
+		}
+		f.CloseAsync()
+	}
+}
+
+func TestFilesModTimeWindow(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"old":    "foo",
+		"recent": "bar",
+		"new":    "baz",
+	})
+	now := time.Now()
+	for name, modTime := range map[string]time.Time{
+		"old":    now.Add(-time.Hour * 48),
+		"recent": now.Add(-time.Hour * 2),
+	} {
+		if err = os.Chtimes(filepath.Join(tmpDir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]struct {
+		newerThan, olderThan string
+		exp                  []string
+	}{
+		"newer than": {
+			newerThan: "24h",
+			exp:       []string{"new", "recent"},
+		},
+		"older than": {
+			olderThan: "1h",
+			exp:       []string{"old", "recent"},
+		},
+		"window": {
+			newerThan: "24h",
+			olderThan: "1h",
+			exp:       []string{"recent"},
+		},
+		"timestamp": {
+			newerThan: now.Add(-time.Hour * 24).Format(time.RFC3339),
+			exp:       []string{"new", "recent"},
+		},
+	}
+
+	for name, test := range tests {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.NewerThan = test.newerThan
+		conf.OlderThan = test.olderThan
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var act []string
+		for path := range readAllFiles(t, f) {
+			act = append(act, filepath.Base(path))
+		}
+		sort.Strings(act)
+		if !reflect.DeepEqual(act, test.exp) {
+			t.Errorf("%v: wrong files: %v != %v", name, act, test.exp)
+		}
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.NewerThan = "yesterday"
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad newer_than")
+	}
+}
+
+func TestFilesExtensionDelimiters(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("quz\x1equx"))
+	zw.Close()
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.csv":    "foo\nbar",
+		"b.DAT":    "baz\x1ebuz\nbev",
+		"c.dat.gz": gzipped.String(),
+		"d.txt":    "qix|quz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "name"
+	conf.Codec = "auto"
+	conf.LineDelimited = true
+	conf.ExtDelims = map[string]string{
+		"dat":  "\x1e",
+		".txt": "|",
+	}
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"foo", "bar", "baz", "buz\nbev", "quz", "qux", "qix", "quz"}
+	var act []string
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	conf.ExtDelims = map[string]string{"dat": ""}
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from empty delimiter")
+	}
+}
+
+func TestFilesExtensionDelimitersHeader(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.csv": "name\nfoo",
+		"b.dat": "name\x1ebar",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.HeaderMetadata = true
+	conf.ExtDelims = map[string]string{"dat": "\x1e"}
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	act := map[string][2]string{}
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := msg.Get(0)
+		act[filepath.Base(p.Metadata().Get("path"))] = [2]string{
+			p.Metadata().Get("header"), string(p.Get()),
+		}
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	exp := map[string][2]string{
+		"a.csv": {"name", "foo"},
+		"b.dat": {"name", "bar"},
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestFilesEmitEOD(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a/1":   "foo\nbar",
+		"a/b/2": "baz",
+		"a/3":   "buz",
+		"c/4":   "qux",
+	})
+
+	dirA, dirB, dirC := filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "a", "b"), filepath.Join(tmpDir, "c")
+
+	tests := map[string]struct {
+		lineDelimited bool
+		groupByDir    bool
+		exp           []string
+	}{
+		"whole files": {
+			exp: []string{"foo\nbar", "buz", "eod:" + dirA, "baz", "eod:" + dirB, "qux", "eod:" + dirC},
+		},
+		"lines": {
+			lineDelimited: true,
+			exp:           []string{"foo", "bar", "buz", "eod:" + dirA, "baz", "eod:" + dirB, "qux", "eod:" + dirC},
+		},
+		"group by dir": {
+			groupByDir: true,
+			exp:        []string{"foo\nbar", "buz", "eod:" + dirA, "baz", "eod:" + dirB, "qux", "eod:" + dirC},
+		},
+	}
+
+	for name, test := range tests {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Sort = "name"
+		conf.EmitEOD = true
+		conf.LineDelimited = test.lineDelimited
+		conf.GroupByDir = test.groupByDir
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		var act []string
+		for {
+			msg, err := f.Read()
+			if err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg.Iter(func(i int, p types.Part) error {
+				if p.Metadata().Get("eod") == "true" {
+					act = append(act, "eod:"+p.Metadata().Get("dir"))
+				} else {
+					act = append(act, string(p.Get()))
+				}
+				return nil
+			})
+			if err = f.Acknowledge(nil); err != nil {
+				t.Error(err)
+			}
+		}
+		if !reflect.DeepEqual(act, test.exp) {
+			t.Errorf("%v: wrong result: %q != %q", name, act, test.exp)
+		}
+	}
+}
+
+func TestFilesDetectContentType(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	htmlDoc := "<html><body>" + strings.Repeat("foo", 200) + "</body></html>"
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":     htmlDoc,
+		"b.txt": "hello world",
+		"c":     "%PDF-1.4",
+	})
+
+	exp := map[string]string{
+		"a":     "text/html; charset=utf-8",
+		"b.txt": "text/plain; charset=utf-8",
+		"c":     "application/pdf",
+	}
+
+	for _, lineDelimited := range []bool{false, true} {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.DetectType = true
+		conf.LineDelimited = lineDelimited
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		act := map[string]string{}
+		for {
+			msg, err := f.Read()
+			if err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Base(msg.Get(0).Metadata().Get("path"))
+			act[name] = msg.Get(0).Metadata().Get("content_type")
+			if name == "a" {
+				if content := string(msg.Get(0).Get()); content != htmlDoc {
+					t.Errorf("Wrong content: %v != %v", content, htmlDoc)
+				}
+			}
+			if err = f.Acknowledge(nil); err != nil {
+				t.Error(err)
+			}
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong content types with line_delimited %v: %v != %v", lineDelimited, act, exp)
+		}
+	}
+}
+
+func TestFilesSymlinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rootDir, outsideDir := filepath.Join(tmpDir, "root"), filepath.Join(tmpDir, "outside")
+	writeTestFiles(t, tmpDir, map[string]string{
+		"root/a":     "foo",
+		"root/sub/b": "bar",
+		"outside/c":  "baz",
+	})
+	for link, target := range map[string]string{
+		"root/link_a":   filepath.Join(rootDir, "a"),
+		"root/ext":      outsideDir,
+		"root/sub/loop": rootDir,
+	} {
+		if err = os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]map[string]string{
+		"skip": {
+			filepath.Join(rootDir, "a"):     "foo",
+			filepath.Join(rootDir, "sub/b"): "bar",
+		},
+		"follow": {
+			filepath.Join(rootDir, "a"):      "foo",
+			filepath.Join(rootDir, "ext/c"):  "baz",
+			filepath.Join(rootDir, "link_a"): "foo",
+			filepath.Join(rootDir, "sub/b"):  "bar",
+		},
+	}
+
+	for strategy, exp := range tests {
+		conf := NewFilesConfig()
+		conf.Path = rootDir
+		conf.Symlinks = strategy
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for %v: %v != %v", strategy, act, exp)
+		}
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = rootDir
+	conf.Symlinks = "error"
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from symlink")
+	}
+
+	conf.Symlinks = "nope"
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad symlinks strategy")
+	}
+}
+
+func TestFilesTailLines(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("foo\nbar\nbaz\n"))
+	zw.Close()
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":    "foo\nbar\nbaz\n",
+		"b":    "foo\nbar\nbaz",
+		"c":    "foo",
+		"d.gz": gzipped.String(),
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Codec = "auto"
+	conf.TailLines = 2
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		filepath.Join(tmpDir, "a"):    "bar\nbaz\n",
+		filepath.Join(tmpDir, "b"):    "bar\nbaz",
+		filepath.Join(tmpDir, "c"):    "foo",
+		filepath.Join(tmpDir, "d.gz"): "bar\nbaz\n",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	conf.LineDelimited = true
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	var act []string
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	sort.Strings(act)
+	if exp := []string{"bar", "bar", "bar", "baz", "baz", "baz", "foo"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	conf.HeaderMetadata = true
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from tail_lines with header_metadata")
+	}
+}
+
+func TestFilesTailOffset(t *testing.T) {
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, strings.Repeat("x", i%37))
+	}
+
+	for _, delim := range []string{"\n", "\r\n", "<->"} {
+		for _, trailing := range []bool{false, true} {
+			content := strings.Join(lines, delim)
+			if trailing {
+				content += delim
+			}
+			r := strings.NewReader(content)
+
+			for _, n := range []int{1, 2, 100, 1234, 4999, 5000, 6000} {
+				offset, err := tailOffset(r, int64(len(content)), n, []byte(delim))
+				if err != nil {
+					t.Fatal(err)
+				}
+				expLines := lines
+				if n < len(lines) {
+					expLines = lines[len(lines)-n:]
+				}
+				exp := strings.Join(expLines, delim)
+				if trailing {
+					exp += delim
+				}
+				if act := content[offset:]; act != exp {
+					t.Errorf("Wrong tail for delim %q, trailing %v and n %v: %v != %v", delim, trailing, n, len(act), len(exp))
+				}
+			}
+		}
+	}
+}
+
+func TestFilesListOnly(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":     "foo",
+		"sub/b": "barbaz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.ListOnly = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	act := map[string]string{}
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := msg.Get(0)
+		if len(p.Get()) > 0 {
+			t.Errorf("Expected empty message, received: %s", p.Get())
+		}
+		if len(p.Metadata().Get("mod_time")) == 0 {
+			t.Error("Expected mod_time metadata")
+		}
+		act[p.Metadata().Get("path")] = p.Metadata().Get("size_bytes")
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	exp := map[string]string{
+		filepath.Join(tmpDir, "a"):     "3",
+		filepath.Join(tmpDir, "sub/b"): "6",
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	conf.LineDelimited = true
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from list_only with line_delimited")
+	}
+}
+
+func TestFilesMaxConcurrency(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{}
+	exp := map[string]string{}
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("%02d", i)
+		files[name] = "content " + name
+		exp[filepath.Join(tmpDir, name)] = "content " + name
+	}
+	files["bad.gz"] = "not gzipped"
+	writeTestFiles(t, tmpDir, files)
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.MaxConcurrency = 4
+	conf.Codec = "auto"
+	conf.OnError = "skip"
+	conf.DeleteOnFinish = true
+	conf.Checkpoint = filepath.Join(tmpDir, "..", filepath.Base(tmpDir)+".checkpoint")
+	defer os.Remove(conf.Checkpoint)
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := readAllFiles(t, f)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	for path := range exp {
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected file '%v' to be deleted: %v", path, err)
+		}
+	}
+	checkpoint, err := ioutil.ReadFile(conf.Checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := filepath.Join(tmpDir, "bad.gz"); string(checkpoint) != exp {
+		t.Errorf("Wrong checkpoint: %s != %v", checkpoint, exp)
+	}
+
+	conf.GroupByDir = true
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from max_concurrency with group_by_dir")
+	}
+}
+
+func TestFilesConcurrentCheckpoint(t *testing.T) {
+	f := &Files{
+		dispatched: []string{"a", "b", "c", "d"},
+		completed:  map[string]int{},
+	}
+	if exp, act := "", f.completeDispatched([]string{"b", "c"}); exp != act {
+		t.Errorf("Wrong checkpoint: %v != %v", act, exp)
+	}
+	if exp, act := "c", f.completeDispatched([]string{"a"}); exp != act {
+		t.Errorf("Wrong checkpoint: %v != %v", act, exp)
+	}
+	if exp, act := "d", f.completeDispatched([]string{"d"}); exp != act {
+		t.Errorf("Wrong checkpoint: %v != %v", act, exp)
+	}
+	if len(f.dispatched) > 0 || len(f.completed) > 0 {
+		t.Errorf("Expected no remaining files: %v %v", f.dispatched, f.completed)
+	}
+}
+
+func writeTestArchives(t *testing.T, dir string) {
+	t.Helper()
+
+	var tarBuf bytes.Buffer
+	gw := gzip.NewWriter(&tarBuf)
+	tw := tar.NewWriter(gw)
+	for _, e := range []struct {
+		name, content string
+		dir           bool
+	}{
+		{name: "d/", dir: true},
+		{name: "d/a.txt", content: "foo"},
+		{name: "b.txt", content: "barbar"},
+	} {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content))}
+		if e.dir {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	if _, err := zw.Create("e/"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := zw.Create("e/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, dir, map[string]string{
+		"bundle.tgz": tarBuf.String(),
+		"bundle.zip": zipBuf.String(),
+		"plain.txt":  "qux",
+	})
+}
+
+func TestFilesArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestArchives(t, tmpDir)
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Archive = "auto"
+	conf.DeleteOnFinish = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	act := map[string]string{}
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := msg.Get(0).Metadata()
+		key := filepath.Base(meta.Get("path")) + ":" + meta.Get("entry_name")
+		act[key] = string(msg.Get(0).Get()) + ":" + meta.Get("entry_size")
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	exp := map[string]string{
+		"bundle.tgz:d/a.txt": "foo:3",
+		"bundle.tgz:b.txt":   "barbar:6",
+		"bundle.zip:e/c.txt": "baz:3",
+		"plain.txt:":         "qux:",
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	for _, name := range []string{"bundle.tgz", "bundle.zip", "plain.txt"} {
+		if _, err = os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected file '%v' to be deleted: %v", name, err)
+		}
+	}
+
+	conf.LineDelimited = true
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from archive with line_delimited")
+	}
+}
+
+func TestFilesArchiveCorrupt(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.zip": "not a zip",
+		"b.tar": "not a tarball, but long enough to fill the first header block",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Archive = "auto"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(); err == nil {
+		t.Error("Expected error from corrupt archive")
+	}
+
+	conf.OnError = "skip"
+	f, err = NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := readAllFiles(t, f); len(act) > 0 {
+		t.Errorf("Expected corrupt archives to be skipped: %v", act)
+	}
+}
+
+func TestFilesStabilityWindow(t *testing.T) {
+	for _, watch := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		writeTestFiles(t, tmpDir, map[string]string{
+			"a": "foo",
+			"b": "ba",
+		})
+
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.StabilityWindow = "100ms"
+		conf.Watch = watch
+		conf.PollInterval = "10ms"
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The write to b settles during the first stability window.
+		go func() {
+			<-time.After(time.Millisecond * 30)
+			file, err := os.OpenFile(filepath.Join(tmpDir, "b"), os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			file.Write([]byte("r"))
+			file.Close()
+		}()
+
+		if err = f.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		act := map[string]string{}
+		for len(act) < 2 {
+			msg, err := f.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			act[filepath.Base(msg.Get(0).Metadata().Get("path"))] = string(msg.Get(0).Get())
+			if err = f.Acknowledge(nil); err != nil {
+				t.Error(err)
+			}
+		}
+		if exp := map[string]string{"a": "foo", "b": "bar"}; !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result with watch %v: %v != %v", watch, act, exp)
+		}
+		f.CloseAsync()
+	}
+
+	conf := NewFilesConfig()
+	conf.StabilityWindow = "nope"
+	if _, err := newFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad stability window")
+	}
+}
+
+func TestFilesCodecAuto(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("foo"))
+	zw.Close()
+
+	// The string "bar" compressed with bzip2.
+	bzipped := "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x5c\xde\xd7\xee\x00\x00" +
+		"\x00\x81\x80\x30\x00\x10\x00\x20\x00\x21\x98\x19\x81\x61\x77\x24" +
+		"\x53\x85\x09\x05\xcd\xed\x7e\xe0"
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a.gz":    gzipped.String(),
+		"b.bz2":   bzipped,
+		"c.data":  gzipped.String(),
+		"d.dat":   bzipped,
+		"e.txt":   "baz",
+		"f.weird": "qux",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Codec = "auto"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	act := map[string]string{}
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := msg.Get(0)
+		act[filepath.Base(p.Metadata().Get("path"))] = string(p.Get()) + ":" + p.Metadata().Get("codec")
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	exp := map[string]string{
+		"a.gz":    "foo:gzip",
+		"b.bz2":   "bar:bzip2",
+		"c.data":  "foo:gzip",
+		"d.dat":   "bar:bzip2",
+		"e.txt":   "baz:none",
+		"f.weird": "qux:none",
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestFilesSkipHidden(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":          "foo",
+		".DS_Store":  "nope",
+		".git/b":     "nope",
+		"c/.hidden":  "nope",
+		"c/d":        "bar",
+		"c/e.d/f":    "baz",
+		".gitignore": "nope",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.SkipHidden = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		filepath.Join(tmpDir, "a"):       "foo",
+		filepath.Join(tmpDir, "c/d"):     "bar",
+		filepath.Join(tmpDir, "c/e.d/f"): "baz",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	// The configured path is read even when hidden.
+	conf = NewFilesConfig()
+	conf.Path = filepath.Join(tmpDir, ".git")
+	conf.SkipHidden = true
+
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+
+	exp = map[string]string{
+		filepath.Join(tmpDir, ".git/b"): "nope",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestFilesOnAck(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "a",
+		"b": "b",
+		"c": "c",
+	})
+
+	archiveDir := filepath.Join(tmpDir, "archive")
+	if err = os.Mkdir(archiveDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Include = "[abc]"
+	conf.Sort = "name"
+
+	var acks []string
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop(), OptFilesSetOnAck(func(path string, err error) {
+		rel, _ := filepath.Rel(tmpDir, path)
+		if err != nil {
+			acks = append(acks, rel+": "+err.Error())
+			return
+		}
+		acks = append(acks, rel)
+		if rerr := os.Rename(path, filepath.Join(archiveDir, rel)); rerr != nil {
+			t.Error(rerr)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ackErr := range []error{nil, errors.New("nope"), nil} {
+		if _, err = f.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Acknowledge(ackErr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp := []string{"a", "b: nope", "b", "c"}
+	if !reflect.DeepEqual(exp, acks) {
+		t.Errorf("Wrong acks: %v != %v", acks, exp)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err = os.Stat(filepath.Join(archiveDir, name)); err != nil {
+			t.Errorf("Expected file '%v' to be moved: %v", name, err)
+		}
+	}
+}
+
+func TestFilesPrefetch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{}
+	var names []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("%02d", i)
+		files[name] = "foo" + name
+		names = append(names, name)
+	}
+	writeTestFiles(t, tmpDir, files)
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "name"
+	conf.DeleteOnFinish = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop(), OptFilesSetPrefetch(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		f.CloseAsync()
+		if err := f.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range names {
+		msg, err := f.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := string(msg.Get(0).Get()), "foo"+name; act != exp {
+			t.Errorf("Wrong result: %v != %v", act, exp)
+		}
+		if i+1 < len(names) {
+			// Prefetched files remain until acknowledged.
+			if _, err = os.Stat(filepath.Join(tmpDir, names[i+1])); err != nil {
+				t.Errorf("Expected prefetched file to remain: %v", err)
+			}
+		}
+		if err = f.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected file '%v' to be deleted: %v", name, err)
+		}
+	}
+	if _, err = f.Read(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrTypeClosed)
+	}
+
+	conf.LineDelimited = true
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop(), OptFilesSetPrefetch(3)); err == nil {
+		t.Error("Expected error from prefetch with line_delimited")
+	}
+}
+
+func TestFilesExpandEnv(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"foo/a": "foo",
+		"bar/b": "bar",
+	})
+
+	os.Setenv("BENTHOS_TEST_FILES_DIR", tmpDir)
+	os.Setenv("BENTHOS_TEST_FILES_TENANT", "foo")
+	os.Setenv("BENTHOS_TEST_FILES_EMPTY", "")
+	defer func() {
+		os.Unsetenv("BENTHOS_TEST_FILES_DIR")
+		os.Unsetenv("BENTHOS_TEST_FILES_TENANT")
+		os.Unsetenv("BENTHOS_TEST_FILES_EMPTY")
+	}()
+
+	conf := NewFilesConfig()
+	conf.Path = "${BENTHOS_TEST_FILES_DIR}/$BENTHOS_TEST_FILES_TENANT"
+	conf.ExpandEnv = true
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		filepath.Join(tmpDir, "foo/a"): "foo",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	conf = NewFilesConfig()
+	conf.Roots = []string{"${BENTHOS_TEST_FILES_DIR}/bar"}
+	conf.ExpandEnv = true
+
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+	exp = map[string]string{
+		filepath.Join(tmpDir, "bar/b"): "bar",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	for _, path := range []string{
+		"${BENTHOS_TEST_FILES_EMPTY}",
+		"${BENTHOS_TEST_FILES_DIR}/${BENTHOS_TEST_FILES_UNSET}",
+	} {
+		conf = NewFilesConfig()
+		conf.Path = path
+		conf.ExpandEnv = true
+		if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+			t.Errorf("Expected error from path '%v'", path)
+		}
+	}
+}
+
+func TestFilesPaths(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":   "foo",
+		"b/c": "bar",
+		"b/d": "baz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Paths = []string{
+		filepath.Join(tmpDir, "b/d"),
+		filepath.Join(tmpDir, "missing"),
+		filepath.Join(tmpDir, "b"),
+		filepath.Join(tmpDir, "a"),
+	}
+	conf.OnError = "skip"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var act []string
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = f.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := []string{"baz", "foo"}; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	conf.OnError = "abort"
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(); err == nil {
+		t.Error("Expected error from missing file")
+	}
+
+	conf.Path = tmpDir
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from paths combined with path")
+	}
+}
+
+func TestFilesNoRetryFailed(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo",
+		"b": "bar",
+		"c": "baz\nqux",
+		"d": "quz",
+	})
+
+	for _, lineDelimited := range []bool{false, true} {
+		conf := NewFilesConfig()
+		conf.Path = tmpDir
+		conf.Sort = "name"
+		conf.DeleteOnFinish = true
+		conf.RetryFailed = false
+		conf.LineDelimited = lineDelimited
+		conf.Include = "[ab]"
+		if lineDelimited {
+			conf.Include = "[cd]"
+		}
+
+		f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		ackErrs := []error{errors.New("nope"), nil}
+		if lineDelimited {
+			ackErrs = []error{nil, errors.New("nope"), nil}
+		}
+		for _, ackErr := range ackErrs {
+			if _, err = f.Read(); err != nil {
+				t.Fatal(err)
+			}
+			if err = f.Acknowledge(ackErr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err = f.Read(); err != types.ErrTypeClosed {
+			t.Errorf("Wrong error: %v != %v", err, types.ErrTypeClosed)
+		}
+	}
+
+	for name, remains := range map[string]bool{"a": true, "b": false, "c": true, "d": false} {
+		_, err = os.Stat(filepath.Join(tmpDir, name))
+		if remains && err != nil {
+			t.Errorf("Expected file '%v' to remain: %v", name, err)
+		}
+		if !remains && !os.IsNotExist(err) {
+			t.Errorf("Expected file '%v' to be deleted: %v", name, err)
+		}
+	}
+}
+
+func TestFilesDepth(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a":     "foo",
+		"b/c":   "bar",
+		"b/d/e": "baz",
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.MetadataPrefix = "files_"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	act := map[string]string{}
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act[string(msg.Get(0).Get())] = msg.Get(0).Metadata().Get("files_depth")
+		if err = f.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := map[string]string{"foo": "0", "bar": "1", "baz": "2"}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	// A file configured as the path is directly within it.
+	conf.Path = filepath.Join(tmpDir, "b/d/e")
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := f.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := msg.Get(0).Metadata().Get("files_depth"); act != "0" {
+		t.Errorf("Wrong depth: %v != 0", act)
+	}
+}
+
+func TestFilesMaxFileSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo",
+		"b": "barbaz",
+		"c": strings.Repeat("x", 100),
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.ReadChunkSize = 2
+	conf.MaxFileSize = 6
+	conf.OnError = "skip"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		filepath.Join(tmpDir, "a"): "foo",
+		filepath.Join(tmpDir, "b"): "barbaz",
+	}
+	if act := readAllFiles(t, f); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	conf.Path = filepath.Join(tmpDir, "c")
+	conf.OnError = "abort"
+	if f, err = NewFiles(conf, log.Noop(), metrics.Noop()); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(); err == nil || !strings.Contains(err.Error(), "exceeds the max file size") {
+		t.Errorf("Expected max file size error, received: %v", err)
+	}
+
+	conf.ReadChunkSize = 0
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from zero read chunk size")
+	}
+}
+
+func TestFilesHeadBytes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestFiles(t, tmpDir, map[string]string{
+		"a": "foo",
+		"b": "barbaz",
+		"c": strings.Repeat("x", 100),
+	})
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Sort = "name"
+	conf.ReadChunkSize = 2
+	conf.HeadBytes = 3
+	conf.MaxFileSize = 50
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		content   string
+		truncated string
+	}
+	exp := []result{
+		{content: "foo"},
+		{content: "bar", truncated: "true"},
+		{content: "xxx", truncated: "true"},
+	}
+	var act []result
+	for {
+		msg, err := f.Read()
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, result{
+			content:   string(msg.Get(0).Get()),
+			truncated: msg.Get(0).Metadata().Get("truncated"),
+		})
+		if err = f.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	conf.LineDelimited = true
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from head_bytes with line_delimited")
+	}
+
+	conf.LineDelimited = false
+	conf.HeadBytes = -1
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from negative head_bytes")
+	}
+}
+
+func TestFilesPollBackoff(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_file_input_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	conf := NewFilesConfig()
+	conf.Path = tmpDir
+	conf.Watch = true
+	conf.PollInterval = "1ms"
+	conf.MaxPollInterval = "5ms"
+
+	f, err := NewFiles(conf, log.Noop(), metrics.Noop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := f.(*Files)
+	interval := files.pollInterval
+	var act []time.Duration
+	for i := 0; i < 4; i++ {
+		interval = files.nextPollInterval(interval)
+		act = append(act, interval)
+	}
+	exp := []time.Duration{
+		time.Millisecond * 2,
+		time.Millisecond * 4,
+		time.Millisecond * 5,
+		time.Millisecond * 5,
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong intervals: %v != %v", act, exp)
+	}
+
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-time.After(time.Millisecond * 50)
+		writeTestFiles(t, tmpDir, map[string]string{
+			"a": "foo",
+		})
+	}()
+	msg, err := f.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong content: %v != %v", act, exp)
+	}
+	if err = f.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+	f.CloseAsync()
+	if err = f.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+
+	conf.MaxPollInterval = "500us"
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from max poll interval below poll interval")
+	}
+	conf.MaxPollInterval = "nope"
+	if _, err = NewFiles(conf, log.Noop(), metrics.Noop()); err == nil {
+		t.Error("Expected error from bad max poll interval")
+	}
+}
+
 //------------------------------------------------------------------------------
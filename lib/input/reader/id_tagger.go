@@ -0,0 +1,107 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+	"github.com/gofrs/uuid"
+)
+
+//------------------------------------------------------------------------------
+
+// UUIDv4 generates a random version 4 UUID. It is the default generator of an
+// IDTagger.
+func UUIDv4() string {
+	u4, err := uuid.NewV4()
+	if err != nil {
+		panic(err)
+	}
+	return u4.String()
+}
+
+// IDTagger is a wrapper for reader.Type implementations that sets a unique
+// identifier as the metadata field `message_id` of each message part read,
+// allowing messages to be traced from the moment they are ingested. Parts that
+// already have an identifier keep it, and so a message that is resent by a
+// wrapped reader such as a Preserver retains its identifiers.
+type IDTagger struct {
+	r         Type
+	generator func() string
+}
+
+// NewIDTagger returns a new IDTagger wrapper around a reader.Type, where the
+// identifier of each message part is obtained by calling generator. If the
+// generator is nil then UUIDv4 is used.
+func NewIDTagger(r Type, generator func() string) *IDTagger {
+	if generator == nil {
+		generator = UUIDv4
+	}
+	return &IDTagger{
+		r:         r,
+		generator: generator,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Connect attempts to establish a connection to the source, if unsuccessful
+// returns an error. If the attempt is successful (or not necessary) returns
+// nil.
+func (i *IDTagger) Connect() error {
+	return i.r.Connect()
+}
+
+// Acknowledge instructs whether messages read since the last Acknowledge call
+// were successfully propagated.
+func (i *IDTagger) Acknowledge(err error) error {
+	return i.r.Acknowledge(err)
+}
+
+// Read attempts to read a new message from the source and tags each part of it
+// that isn't already tagged with a unique identifier.
+func (i *IDTagger) Read() (types.Message, error) {
+	msg, err := i.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	msg.Iter(func(_ int, p types.Part) error {
+		if len(p.Metadata().Get("message_id")) == 0 {
+			p.Metadata().Set("message_id", i.generator())
+		}
+		return nil
+	})
+	return msg, nil
+}
+
+// CloseAsync triggers the asynchronous closing of the reader.
+func (i *IDTagger) CloseAsync() {
+	i.r.CloseAsync()
+}
+
+// WaitForClose blocks until either the reader is finished closing or a timeout
+// occurs.
+func (i *IDTagger) WaitForClose(tout time.Duration) error {
+	return i.r.WaitForClose(tout)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,127 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+)
+
+//------------------------------------------------------------------------------
+
+func TestIDTaggerGenerator(t *testing.T) {
+	rdr := newMockReader()
+	rdr.msgToSnd = message.New([][]byte{[]byte("foo"), []byte("bar")})
+
+	var n int
+	r := NewIDTagger(rdr, func() string {
+		n++
+		return strconv.Itoa(n)
+	})
+
+	go func() {
+		rdr.readChan <- nil
+		rdr.readChan <- errors.New("nope")
+		rdr.ackChan <- nil
+	}()
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range []string{"1", "2"} {
+		if act := msg.Get(i).Metadata().Get("message_id"); act != exp {
+			t.Errorf("Wrong id of part %v: %v != %v", i, act, exp)
+		}
+	}
+	if _, err = r.Read(); err == nil || err.Error() != "nope" {
+		t.Errorf("Expected error, received: %v", err)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIDTaggerResend(t *testing.T) {
+	rdr := newMockReader()
+	rdr.msgToSnd = message.New([][]byte{[]byte("foo")})
+
+	var n int
+	r := NewIDTagger(NewPreserver(rdr), func() string {
+		n++
+		return strconv.Itoa(n)
+	})
+
+	go func() {
+		rdr.readChan <- nil
+		rdr.ackChan <- nil
+	}()
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "1", msg.Get(0).Metadata().Get("message_id"); act != exp {
+		t.Errorf("Wrong id: %v != %v", act, exp)
+	}
+	if err = r.Acknowledge(errors.New("nope")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resent message keeps its id.
+	if msg, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "1", msg.Get(0).Metadata().Get("message_id"); act != exp {
+		t.Errorf("Wrong id of resent message: %v != %v", act, exp)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIDTaggerDefault(t *testing.T) {
+	rdr := newMockReader()
+	rdr.msgToSnd = message.New([][]byte{[]byte("foo"), []byte("bar")})
+
+	r := NewIDTagger(rdr, nil)
+	go func() {
+		rdr.readChan <- nil
+	}()
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := msg.Get(0).Metadata().Get("message_id")
+	b := msg.Get(1).Metadata().Get("message_id")
+	if len(a) != 36 || len(b) != 36 {
+		t.Errorf("Expected UUIDs, received: %v, %v", a, b)
+	}
+	if a == b {
+		t.Errorf("Expected unique ids, received: %v, %v", a, b)
+	}
+}
+
+//------------------------------------------------------------------------------
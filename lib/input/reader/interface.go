@@ -47,3 +47,15 @@ type Type interface {
 
 	types.Closable
 }
+
+// PartError is an error provided to Acknowledge that identifies which parts of
+// the messages consumed since the last acknowledgement failed to be propagated.
+// Readers that support it can resend only the failed parts.
+type PartError interface {
+	error
+
+	// FailedParts returns the indexes of the parts that failed, counted across
+	// all messages consumed since the last acknowledgement in the order that
+	// they were read.
+	FailedParts() []int
+}
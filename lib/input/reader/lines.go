@@ -23,30 +23,196 @@ package reader
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"github.com/DataDog/zstd"
 	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
 	"github.com/Jeffail/benthos/v3/lib/types"
+	"github.com/Jeffail/gabs/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 )
 
 //------------------------------------------------------------------------------
 
+// ReadError is returned by Lines when a line or handle cannot be read, and
+// classifies whether the failure is transient. A retryable error, such as a
+// timeout whilst reading a handle, might succeed when attempted again, whereas
+// one that is not retryable, such as a line that exceeds the maximum buffer
+// size, would fail again.
+type ReadError struct {
+	Err       error
+	Retryable bool
+}
+
+// Error returns the message of the underlying error.
+func (e *ReadError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// classifyReadError wraps an error returned from scanning a handle in a
+// ReadError. Timeouts and temporary errors are retryable, whereas framing
+// errors and any other errors are not.
+func classifyReadError(err error) *ReadError {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return &ReadError{Err: err}
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return &ReadError{Err: err, Retryable: true}
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return &ReadError{Err: err, Retryable: true}
+	}
+	return &ReadError{Err: err}
+}
+
+//------------------------------------------------------------------------------
+
 // Lines is a reader implementation that continuously reads line delimited
-// messages from an io.Reader type.
+// messages from an io.Reader type. Each message part is given a `line_number`
+// metadata field, which is the 1-indexed position of the line within the
+// current io.Reader, and `start_offset` and `end_offset` metadata fields, which
+// are the byte range of the line and its delimiter within the current
+// io.Reader after any decompression and decoding of the stream.
 type Lines struct {
+	// Bytes advanced by the scanner over the current handle and over all
+	// handles, which are accessed atomically and are therefore kept at the
+	// start of the struct for alignment.
+	bytesRead      int64
+	totalBytesRead int64
+
 	handleCtor func() (io.Reader, error)
 	onClose    func()
 
-	handle  io.Reader
-	scanner *bufio.Scanner
+	handle       io.Reader
+	handleLabel  string
+	decompressor io.Closer
+	scanner      *bufio.Scanner
+
+	// A scan running in the background and the partial message it belongs
+	// to, these are left over when a read is cancelled.
+	pendingScan chan scanResult
+	pendingMsg  types.Message
+
+	// The number of lines scanned from the current handle.
+	lineNumber int
+
+	// The state of the scan of the current handle, which is modified by the
+	// split function and therefore belongs to whichever goroutine is running
+	// the scan, and the most recently scanned token, which is handed over
+	// from the scan once it stops.
+	scanState scanState
+	token     lineToken
+
+	offsets lineOffsets
 
 	messageBuffer      *bytes.Buffer
 	messageBufferIndex int
 
-	maxBuffer int
-	multipart bool
-	delimiter []byte
+	// Parts read since the last acknowledgement, and a message of parts that
+	// failed to be propagated which is due to be read again.
+	unackedParts []types.Part
+	requeued     types.Message
+
+	maxBuffer     int
+	initialBuffer int
+	multipart     bool
+	terminator    []byte
+	collapse      bool
+	delimiter     []byte
+	delimFunc     func() string
+	delimiters    [][]byte
+	handleDelim   []byte
+	delimRegexp   *regexp.Regexp
+	customSplit   bufio.SplitFunc
+	emitEmpty     bool
+	recordLen     int
+	emitPartial   bool
+	wholeStream   bool
+	encoding      encoding.Encoding
+	stripBOM      bool
+	labeler       func(io.Reader) string
+
+	continuous bool
+	carried    []byte
+	flushCarry bool
+
+	pooled     bool
+	pooledBufs []*[]byte
+
+	decompression string
+
+	lineDecoder         string
+	decodeErrorStrategy string
+
+	csv          bool
+	csvHeaders   []string
+	csvComma     rune
+	csvHeaderRow bool
+	rowHeaders   []string
+
+	keyExtractor func([]byte) (string, error)
+
+	lineTransform func([]byte) ([]byte, error)
+	trimCR        bool
+	filter        func([]byte) bool
+
+	validateJSON        bool
+	invalidJSONStrategy string
+
+	keepDelimiter bool
+
+	oversizeStrategy string
+
+	maxMessages  int
+	maxHandleLen int64
+	messageCount int
+
+	batch     lineBatch
+	timeouts  lineTimeouts
+	rateLimit types.RateLimit
+
+	eofBehavior      string
+	eofRetryInterval time.Duration
+	eofWaiting       bool
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+
+	// When closing gracefully the open handle is read to its end before the
+	// drained chan is closed and onClose is called.
+	gracefulClose bool
+	handleOpen    int32
+	drainOnce     sync.Once
+	drained       chan struct{}
+
+	stats      metrics.Type
+	mRcvd      metrics.StatCounter
+	mBytes     metrics.StatCounter
+	mPartCount metrics.StatGauge
 }
 
 // NewLines creates a new reader input type.
@@ -71,12 +237,88 @@ func NewLines(
 		maxBuffer:     bufio.MaxScanTokenSize,
 		multipart:     false,
 		delimiter:     []byte("\n"),
+
+		oversizeStrategy: "error",
+		decompression:    "none",
+
+		lineDecoder:         "none",
+		decodeErrorStrategy: "error",
+		invalidJSONStrategy: "drop",
+
+		eofBehavior:      "close",
+		eofRetryInterval: time.Second,
+
+		closeChan: make(chan struct{}),
+		drained:   make(chan struct{}),
+		stats:     metrics.Noop(),
 	}
 
 	for _, opt := range options {
 		opt(&r)
 	}
 
+	r.batch.begin()
+	r.mRcvd = r.stats.GetCounter("lines.received")
+	r.mBytes = r.stats.GetCounter("lines.bytes")
+	r.mPartCount = r.stats.GetGauge("lines.part_count")
+
+	switch r.oversizeStrategy {
+	case "error", "truncate", "skip":
+	default:
+		return nil, fmt.Errorf("oversize strategy not recognised: %v", r.oversizeStrategy)
+	}
+
+	switch r.decompression {
+	case "none", "gzip", "zstd", "auto":
+	default:
+		return nil, fmt.Errorf("decompression not recognised: %v", r.decompression)
+	}
+
+	switch r.lineDecoder {
+	case "none", "base64", "base64url", "hex":
+	default:
+		return nil, fmt.Errorf("line decoder not recognised: %v", r.lineDecoder)
+	}
+
+	if r.recordLen < 0 {
+		return nil, fmt.Errorf("fixed width record length must not be negative: %v", r.recordLen)
+	}
+	if r.recordLen > 0 {
+		r.customSplit = splitFixedWidth(r.recordLen, r.emitPartial)
+	}
+
+	if r.offsets.store != nil && (r.decompression != "none" || r.encoding != nil || r.continuous) {
+		return nil, errors.New("offset store cannot be combined with decompression, encoding or continuous handles")
+	}
+
+	if r.csv {
+		if r.csvComma == 0 {
+			r.csvComma = ','
+		}
+		if r.csvComma == '\r' || r.csvComma == '\n' || r.csvComma == '"' ||
+			r.csvComma == utf8.RuneError || !utf8.ValidRune(r.csvComma) {
+			return nil, fmt.Errorf("csv delimiter not recognised: %q", r.csvComma)
+		}
+	}
+
+	switch r.decodeErrorStrategy {
+	case "error", "passthrough":
+	default:
+		return nil, fmt.Errorf("decode error strategy not recognised: %v", r.decodeErrorStrategy)
+	}
+
+	switch r.invalidJSONStrategy {
+	case "drop", "tag":
+	default:
+		return nil, fmt.Errorf("invalid json strategy not recognised: %v", r.invalidJSONStrategy)
+	}
+
+	switch r.eofBehavior {
+	case "close", "retry":
+	default:
+		return nil, fmt.Errorf("eof behaviour not recognised: %v", r.eofBehavior)
+	}
+
 	return &r, nil
 }
 
@@ -90,14 +332,61 @@ func OptLinesSetMaxBuffer(maxBuffer int) func(r *Lines) {
 	}
 }
 
+// OptLinesSetInitialBuffer is a option func that sets the initial capacity of
+// the line parsing buffer, which avoids repeated reallocations when lines are
+// expected to be large. The buffer still grows up to the size set with
+// OptLinesSetMaxBuffer.
+func OptLinesSetInitialBuffer(size int) func(r *Lines) {
+	return func(r *Lines) {
+		r.initialBuffer = size
+	}
+}
+
+// OptLinesSetAdaptiveBuffer is a option func that sets the line parsing buffer
+// to start at an initial size and double each time a line does not fit, up to
+// a maximum size, beyond which the line is handled according to the oversize
+// strategy. This keeps memory low when lines are small whilst tolerating the
+// occasional large line, and is equivalent to setting both
+// OptLinesSetInitialBuffer and OptLinesSetMaxBuffer.
+func OptLinesSetAdaptiveBuffer(initial, max int) func(r *Lines) {
+	return func(r *Lines) {
+		r.initialBuffer = initial
+		r.maxBuffer = max
+	}
+}
+
 // OptLinesSetMultipart is a option func that sets the boolean flag
-// indicating whether lines should be parsed as multipart or not.
+// indicating whether lines should be parsed as multipart or not. A multipart
+// message that is flushed at the end of a handle without being terminated is
+// given the metadata field `multipart_incomplete` with the value `true`.
 func OptLinesSetMultipart(multipart bool) func(r *Lines) {
 	return func(r *Lines) {
 		r.multipart = multipart
 	}
 }
 
+// OptLinesSetMultipartTerminator is a option func that sets a line that
+// indicates the end of a multipart message. When set empty lines are treated as
+// regular message parts and the terminator line itself is not included in the
+// message. By default a message is ended by an empty line.
+func OptLinesSetMultipartTerminator(terminator []byte) func(r *Lines) {
+	return func(r *Lines) {
+		r.terminator = terminator
+	}
+}
+
+// OptLinesCollapseDelimiters is a option func that sets whether runs of
+// consecutive delimiters should be treated as a single separator, where the
+// empty lines between them are never added to a message. In multipart mode
+// this means that an empty line no longer ends a message, and so messages are
+// only ended by the terminator set with OptLinesSetMultipartTerminator or by
+// the end of a handle.
+func OptLinesCollapseDelimiters(collapse bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.collapse = collapse
+	}
+}
+
 // OptLinesSetDelimiter is a option func that sets the delimiter (default
 // '\n') used to divide lines (message parts) in the stream of data.
 func OptLinesSetDelimiter(delimiter string) func(r *Lines) {
@@ -106,140 +395,1741 @@ func OptLinesSetDelimiter(delimiter string) func(r *Lines) {
 	}
 }
 
-//------------------------------------------------------------------------------
+// OptLinesSetDelimiters is a option func that sets multiple alternative
+// delimiters used to divide lines (message parts) in the stream of data, where
+// each line ends at the earliest occurrence of any of them. When two
+// delimiters match at the same position the longest is used. When set these
+// take precedence over the delimiter set with OptLinesSetDelimiter, and when
+// delimiters are kept each line retains the delimiter that it matched. Each
+// terminated line is given a `delimiter` metadata field naming the delimiter it
+// matched, which is one of lf, crlf, cr, tab or null, or otherwise the hex
+// encoding of the delimiter.
+func OptLinesSetDelimiters(delimiters [][]byte) func(r *Lines) {
+	return func(r *Lines) {
+		r.delimiters = delimiters
+	}
+}
 
-func (r *Lines) closeHandle() {
-	if r.handle != nil {
-		if closer, ok := r.handle.(io.ReadCloser); ok {
-			closer.Close()
-		}
-		r.handle = nil
+// OptLinesSetHandleDelimiter is a option func that sets a function called
+// each time a new handle is opened, which returns the delimiter used to divide
+// lines of that handle. When the function returns an empty string the
+// delimiter set with OptLinesSetDelimiter is used instead.
+func OptLinesSetHandleDelimiter(fn func() string) func(r *Lines) {
+	return func(r *Lines) {
+		r.delimFunc = fn
 	}
-	r.scanner = nil
 }
 
-// Connect attempts to establish a new scanner for an io.Reader.
-func (r *Lines) Connect() error {
-	if r.scanner != nil {
-		return nil
+// OptLinesSetNullDelimited is a option func that sets the delimiter used to
+// divide lines (message parts) to a single NUL byte, as written by tools such
+// as `find -print0`.
+func OptLinesSetNullDelimited() func(r *Lines) {
+	return OptLinesSetDelimiter("\x00")
+}
+
+// OptLinesSetDelimiterRegexp is a option func that sets a regular expression
+// used to divide lines (message parts) in the stream of data. When set this
+// takes precedence over the delimiter set with OptLinesSetDelimiter. The full
+// match of the expression is treated as the delimiter and is therefore not
+// included in the resulting message parts, unless the expression has a
+// capturing group, in which case only the first group is the delimiter. Text
+// matched before the group ends the preceding line and text matched after it
+// begins the next, e.g. `(\n)\d{4}-\d{2}-\d{2}` divides records on date
+// prefixes whilst keeping each date. Delimiters of zero length are ignored.
+func OptLinesSetDelimiterRegexp(re *regexp.Regexp) func(r *Lines) {
+	return func(r *Lines) {
+		r.delimRegexp = re
 	}
-	r.closeHandle() // Just incase we have an open handle without a scanner.
+}
 
-	var err error
-	r.handle, err = r.handleCtor()
-	if err != nil {
-		if err == io.EOF {
-			return types.ErrTypeClosed
-		}
-		return err
+// OptLinesSetSplitFunc is a option func that sets a custom split function to
+// be used by the scanner in place of delimiter scanning. When set the delimiter
+// options are ignored. In multipart mode a token of zero length indicates the
+// end of a message.
+func OptLinesSetSplitFunc(split bufio.SplitFunc) func(r *Lines) {
+	return func(r *Lines) {
+		r.customSplit = split
 	}
+}
 
-	r.scanner = bufio.NewScanner(r.handle)
-	if r.maxBuffer != bufio.MaxScanTokenSize {
-		r.scanner.Buffer([]byte{}, r.maxBuffer)
+// OptLinesSetFixedWidth is a option func that frames the stream into records of
+// a fixed length in bytes rather than scanning for delimiters. A short record
+// at the end of a handle is emitted when emitPartial is true and is otherwise
+// discarded. This takes precedence over OptLinesSetSplitFunc, and a length of
+// zero disables fixed width records.
+func OptLinesSetFixedWidth(length int, emitPartial bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.recordLen = length
+		r.emitPartial = emitPartial
 	}
+}
 
-	r.scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
+// OptLinesSetWholeStream is a option func that sets whether the entire contents
+// of each handle should be read as a single message rather than split into
+// lines. The contents are accumulated until the end of the handle, and a handle
+// larger than the maximum buffer size is handled according to the oversize
+// strategy. This takes precedence over all delimiter and split options.
+func OptLinesSetWholeStream(whole bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.wholeStream = whole
+	}
+}
 
-		if i := bytes.Index(data, r.delimiter); i >= 0 {
-			// We have a full terminated line.
-			return i + len(r.delimiter), data[0:i], nil
-		}
+// OptLinesKeepDelimiter is a option func that sets whether the delimiter of
+// each line should be kept at the end of the resulting message part. A final
+// line that isn't terminated by a delimiter is emitted unchanged.
+func OptLinesKeepDelimiter(keep bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.keepDelimiter = keep
+	}
+}
 
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return len(data), data, nil
-		}
+// OptLinesSetOversizeStrategy is a option func that sets the behaviour of the
+// reader when a line exceeds the maximum buffer size. The strategy "error"
+// (default) returns bufio.ErrTooLong from Read and closes the handle,
+// "truncate" emits the first max buffer bytes of the line and discards the
+// rest, and "skip" drops the line entirely.
+func OptLinesSetOversizeStrategy(strategy string) func(r *Lines) {
+	return func(r *Lines) {
+		r.oversizeStrategy = strategy
+	}
+}
 
-		// Request more data.
-		return 0, nil, nil
-	})
+// OptLinesSetMaxMessages is a option func that sets a maximum number of
+// messages to be read, after which the reader behaves as if the stream has
+// ended. A value of zero or less means there is no limit.
+func OptLinesSetMaxMessages(n int) func(r *Lines) {
+	return func(r *Lines) {
+		r.maxMessages = n
+	}
+}
 
-	return nil
+// OptLinesSetMaxBytesPerHandle is a option func that sets a maximum number of
+// bytes to be read from each handle, counted up to the end of the last line
+// read, after which the handle is closed once
+// the current message has been read and the next call to Connect opens a new
+// handle. Any remaining contents of the closed handle are not read unless the
+// handle constructor provides them again. A value of zero or less means there
+// is no limit.
+func OptLinesSetMaxBytesPerHandle(n int64) func(r *Lines) {
+	return func(r *Lines) {
+		r.maxHandleLen = n
+	}
 }
 
-// Read attempts to read a new line from the io.Reader.
-func (r *Lines) Read() (types.Message, error) {
-	if r.scanner == nil {
-		return nil, types.ErrNotConnected
+// OptLinesSetBatchCount is a option func that sets a number of lines to batch
+// into each message when not in multipart mode, where each line is a part of
+// the message.
+func OptLinesSetBatchCount(n int) func(r *Lines) {
+	return func(r *Lines) {
+		r.batch.count = n
 	}
+}
 
-	msg := message.New(nil)
+// OptLinesSetBatchPeriod is a option func that sets a period after which a
+// batch of lines is returned regardless of its size when not in multipart
+// mode. The period begins after the previous batch was returned.
+func OptLinesSetBatchPeriod(period time.Duration) func(r *Lines) {
+	return func(r *Lines) {
+		r.batch.period = period
+	}
+}
 
-	for r.scanner.Scan() {
-		partSize, err := r.messageBuffer.Write(r.scanner.Bytes())
-		rIndex := r.messageBufferIndex
-		r.messageBufferIndex += partSize
-		if err != nil {
-			return nil, err
+// OptLinesSetDecompression is a option func that sets the algorithm used to
+// decompress the io.Reader before it is scanned for lines. Valid options are
+// "none", "gzip", "zstd" and "auto", where "auto" detects the algorithm from the
+// first bytes of the stream.
+func OptLinesSetDecompression(algorithm string) func(r *Lines) {
+	return func(r *Lines) {
+		r.decompression = algorithm
+	}
+}
+
+// OptLinesSetReadTimeout is a option func that sets a maximum period of time to
+// wait for a line to be read, after which Read returns types.ErrTimeout. The
+// handle remains open and any partially read line is preserved for the next
+// call.
+func OptLinesSetReadTimeout(timeout time.Duration) func(r *Lines) {
+	return func(r *Lines) {
+		r.timeouts.read = timeout
+	}
+}
+
+// OptLinesSetHeartbeat is a option func that sets a period of time after which,
+// if an open handle has not produced a line, Read returns a message containing
+// a single empty part with the metadata field `heartbeat` set to `true`.
+// Heartbeats continue at the same period until a line arrives, and their
+// acknowledgement is ignored. A partially read multipart message is kept for
+// the next call.
+func OptLinesSetHeartbeat(period time.Duration) func(r *Lines) {
+	return func(r *Lines) {
+		r.timeouts.heartbeat = period
+	}
+}
+
+// OptLinesSetIdleTimeout is a option func that sets a maximum period of time
+// that a handle may go without producing a line, after which the handle is
+// closed and Read returns types.ErrNotConnected so that a new handle is created
+// on the next call to Connect. Unlike the read timeout the period is reset by
+// each line read rather than each call to Read. Lines that were read from the
+// handle before it was closed are still returned. A handle that does not
+// implement io.Closer is read in the background so that a blocked read of it
+// can still be abandoned, which leaves that read to finish on its own.
+func OptLinesSetIdleTimeout(timeout time.Duration) func(r *Lines) {
+	return func(r *Lines) {
+		r.timeouts.idle = timeout
+	}
+}
+
+// OptLinesSetEOFBehavior is a option func that sets what happens when the
+// handle constructor returns io.EOF. The default "close" closes the reader,
+// whereas "retry" causes Read to call the constructor again after the retry
+// interval, returning types.ErrTimeout if it still returns io.EOF. This allows
+// the reader to poll a source that may later have more content.
+func OptLinesSetEOFBehavior(behavior string) func(r *Lines) {
+	return func(r *Lines) {
+		r.eofBehavior = behavior
+	}
+}
+
+// OptLinesSetEOFRetryInterval is a option func that sets the period to wait
+// before calling the handle constructor again when the EOF behaviour is
+// "retry" (default 1s).
+func OptLinesSetEOFRetryInterval(interval time.Duration) func(r *Lines) {
+	return func(r *Lines) {
+		r.eofRetryInterval = interval
+	}
+}
+
+// OptLinesSetRateLimit is a option func that sets a rate limit to be accessed
+// before each message is read, where Read blocks until either the rate limit
+// grants access, the read is cancelled, or the reader is closed.
+func OptLinesSetRateLimit(rl types.RateLimit) func(r *Lines) {
+	return func(r *Lines) {
+		r.rateLimit = rl
+	}
+}
+
+// OptLinesSetLineDecoder is a option func that sets a decoder applied to each
+// line before it is added to a message. Valid options are "none", "base64",
+// "base64url" and "hex".
+func OptLinesSetLineDecoder(decoder string) func(r *Lines) {
+	return func(r *Lines) {
+		r.lineDecoder = decoder
+	}
+}
+
+// OptLinesSetDecodeErrorStrategy is a option func that sets what happens to a
+// line that fails to be decoded by the line decoder. Valid options are "error",
+// where Read returns the error, and "passthrough", where the raw line is added
+// to the message with a `decode_error` metadata field describing the error.
+func OptLinesSetDecodeErrorStrategy(strategy string) func(r *Lines) {
+	return func(r *Lines) {
+		r.decodeErrorStrategy = strategy
+	}
+}
+
+// OffsetStore persists the byte offset of the last acknowledged line of a
+// Lines reader so that reading can resume from it after a restart.
+type OffsetStore interface {
+	// Load returns the most recently stored offset, or zero if there is none.
+	Load() int64
+
+	// Store records the offset following the last acknowledged line.
+	Store(offset int64) error
+}
+
+// OptLinesSetOffsetStore is a option func that sets a store for the byte
+// offset of the last acknowledged line, which is updated each time a message is
+// acknowledged successfully. The first handle opened by the reader is seeked to
+// the stored offset, and must therefore implement io.Seeker when the offset is
+// non-zero. Offsets stored after a subsequent handle has been opened refer to
+// that handle.
+func OptLinesSetOffsetStore(store OffsetStore) func(r *Lines) {
+	return func(r *Lines) {
+		r.offsets.store = store
+	}
+}
+
+// OptLinesSetCSV is a option func that parses each line as a CSV record with
+// fields separated by comma, where a zero comma means ','. Each record is
+// emitted as a JSON object mapping headers to field values, and fields beyond
+// the provided headers are keyed by position as col0, col1, and so on. Records
+// cannot span lines. A line that fails to parse is handled in the same way as
+// a line that fails to be decoded, according to the strategy set with
+// OptLinesSetDecodeErrorStrategy.
+func OptLinesSetCSV(headers []string, comma rune) func(r *Lines) {
+	return func(r *Lines) {
+		r.csv = true
+		r.csvHeaders = headers
+		r.csvComma = comma
+	}
+}
+
+// OptLinesSetCSVHeaderRow is a option func that, when headers are not provided
+// to OptLinesSetCSV, consumes the first record of each handle as the header row
+// rather than keying fields by position.
+func OptLinesSetCSVHeaderRow(headerRow bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.csvHeaderRow = headerRow
+	}
+}
+
+// OptLinesSetKeyExtractor is a option func that sets a function used to
+// extract a key from each line, which is added to the message part as a `key`
+// metadata field. The function is given the line after it has been decoded,
+// without its delimiter. An extraction error is handled in the same way as a
+// line that fails to be decoded, according to the strategy set with
+// OptLinesSetDecodeErrorStrategy.
+func OptLinesSetKeyExtractor(extractor func([]byte) (string, error)) func(r *Lines) {
+	return func(r *Lines) {
+		r.keyExtractor = extractor
+	}
+}
+
+// KeyFromRange returns a key extractor for OptLinesSetKeyExtractor that uses
+// the bytes of a line from the start offset up to but excluding the end
+// offset. Lines that end before the end offset result in an error.
+func KeyFromRange(start, end int) func([]byte) (string, error) {
+	return func(line []byte) (string, error) {
+		if start < 0 || end < start {
+			return "", fmt.Errorf("key range %v to %v is invalid", start, end)
+		}
+		if len(line) < end {
+			return "", fmt.Errorf("line of length %v ends before key range end %v", len(line), end)
 		}
+		return string(line[start:end]), nil
+	}
+}
 
-		// WARNING: According to https://golang.org/pkg/bytes/#Buffer.Bytes the
-		// slice returned by Bytes is only correct until the next call to Write.
-		// Since we call Write for multiple part messages, and could potentially
-		// call it on a consecutive Read call before the next Acknowledge, we
-		// are passing slices through messages that are "invalid".
-		//
-		// However, in practice the calls to Write do not overwrite the memory
-		// within the returned slice even if it results in the buffer
-		// re-allocating memory. Since we also ensure that Reset is only called
-		// once messages are no longer in use we should be fine here.
-		//
-		// Regardless, we should regularly revisit this code in order to ensure
-		// that this remains the case. I can't foresee any case where discarded
-		// slices within bytes.Buffer would be wiped or modified during Write,
-		// but since the library does not guarantee this:
-		//
-		// TODO: Have another cheeky gander at
-		// https://golang.org/src/bytes/buffer.go to make sure Write never
-		// mutates a discarded slice during re-allocation. If it does then we
-		// should stop using bytes.Buffer and either eat the allocations or do
-		// some buffer rotations of our own.
-		if partSize > 0 {
-			msg.Append(message.NewPart(r.messageBuffer.Bytes()[rIndex : rIndex+partSize : rIndex+partSize]))
-			if !r.multipart {
-				return msg, nil
-			}
-		} else if r.multipart && msg.Len() > 0 {
-			// Empty line means we're finished reading parts for this
-			// message.
-			return msg, nil
+// KeyFromJSONPath returns a key extractor for OptLinesSetKeyExtractor that
+// parses a line as JSON and uses the value at a dot separated path. String
+// values are used as they are, and other values are serialised as JSON. Lines
+// that aren't valid JSON, or that lack the path, result in an error.
+func KeyFromJSONPath(path string) func([]byte) (string, error) {
+	return func(line []byte) (string, error) {
+		doc, err := gabs.ParseJSON(line)
+		if err != nil {
+			return "", err
 		}
+		if !doc.ExistsP(path) {
+			return "", fmt.Errorf("path '%v' was not found", path)
+		}
+		value := doc.Path(path)
+		if str, ok := value.Data().(string); ok {
+			return str, nil
+		}
+		return value.String(), nil
 	}
+}
 
-	if err := r.scanner.Err(); err != nil {
-		r.closeHandle()
-		return nil, err
+// OptLinesSetLineTransform is a option func that sets a function applied to
+// each line before it is added to a message, and before it is checked for being
+// empty or a multipart terminator. The function is not given the delimiter of
+// the line. A transform that returns an error is handled in the same way as a
+// line that fails to be decoded, according to the strategy set with
+// OptLinesSetDecodeErrorStrategy.
+func OptLinesSetLineTransform(transform func([]byte) ([]byte, error)) func(r *Lines) {
+	return func(r *Lines) {
+		r.lineTransform = transform
 	}
+}
 
-	r.closeHandle()
+// OptLinesSetFilter is a option func that sets a predicate that each line must
+// satisfy in order to be added to a message, lines that fail are skipped
+// entirely. The predicate is given the line without its delimiter, after any
+// line transform but before the line is decoded, and must not retain it.
+func OptLinesSetFilter(predicate func([]byte) bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.filter = predicate
+	}
+}
 
-	if msg.Len() > 0 {
-		return msg, nil
+// LinesContaining returns a predicate for OptLinesSetFilter that accepts lines
+// containing a substring.
+func LinesContaining(substr string) func([]byte) bool {
+	b := []byte(substr)
+	return func(line []byte) bool {
+		return bytes.Contains(line, b)
 	}
-	return nil, types.ErrNotConnected
 }
 
-// Acknowledge confirms whether or not our unacknowledged messages have been
-// successfully propagated or not.
-func (r *Lines) Acknowledge(err error) error {
-	if err == nil && r.messageBuffer != nil {
-		r.messageBuffer.Reset()
-		r.messageBufferIndex = 0
+// LinesMatching returns a predicate for OptLinesSetFilter that accepts lines
+// matching a regular expression.
+func LinesMatching(re *regexp.Regexp) func([]byte) bool {
+	return re.Match
+}
+
+// OptLinesTrimCR is a option func that sets a line transform that removes a
+// trailing carriage return from each line, allowing files with CRLF line
+// endings to be read with a line feed delimiter. Each message part is given
+// the metadata field `line_ending`, which is "crlf" if the line had a carriage
+// return before its delimiter, "lf" if it did not, and "none" if the line was
+// not terminated.
+func OptLinesTrimCR() func(r *Lines) {
+	trim := OptLinesSetLineTransform(func(line []byte) ([]byte, error) {
+		return bytes.TrimSuffix(line, []byte("\r")), nil
+	})
+	return func(r *Lines) {
+		trim(r)
+		r.trimCR = true
 	}
-	return nil
 }
 
-// CloseAsync shuts down the reader input and stops processing requests.
-func (r *Lines) CloseAsync() {
-	r.onClose()
+// OptLinesSetValidateJSON is a option func that sets whether each line should
+// be checked for valid JSON, where lines that are invalid are handled according
+// to the strategy set with OptLinesSetInvalidJSONStrategy. Valid lines are not
+// modified.
+func OptLinesSetValidateJSON(validate bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.validateJSON = validate
+	}
 }
 
-// WaitForClose blocks until the reader input has closed down.
-func (r *Lines) WaitForClose(timeout time.Duration) error {
+// OptLinesSetInvalidJSONStrategy is a option func that sets what happens to a
+// line that fails JSON validation. Valid options are "drop" (default), where
+// the line is discarded, and "tag", where the line is added to the message with
+// a `json_valid` metadata field set to `false`.
+func OptLinesSetInvalidJSONStrategy(strategy string) func(r *Lines) {
+	return func(r *Lines) {
+		r.invalidJSONStrategy = strategy
+	}
+}
+
+// OptLinesSetEncoding is a option func that sets the character encoding of the
+// io.Reader, which is decoded into UTF-8 before being scanned for lines. A byte
+// order mark at the start of the stream identifying UTF-8 or UTF-16 takes
+// precedence over the encoding set.
+func OptLinesSetEncoding(enc encoding.Encoding) func(r *Lines) {
+	return func(r *Lines) {
+		r.encoding = enc
+	}
+}
+
+// OptLinesSetPooledBuffers is a option func that sets whether the contents of
+// each message part should be copied into a buffer taken from a pool shared by
+// all Lines readers, rather than a buffer owned by the reader. Buffers are
+// returned to the pool once the messages they belong to are successfully
+// acknowledged, which reduces allocations under high throughput.
+func OptLinesSetPooledBuffers(pooled bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.pooled = pooled
+	}
+}
+
+// OptLinesStripBOM is a option func that sets whether a UTF-8 byte order mark
+// at the very start of each handle should be removed before it is scanned for
+// lines. When an encoding is also set the mark is removed from the decoded
+// stream.
+func OptLinesStripBOM(strip bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.stripBOM = strip
+	}
+}
+
+// OptLinesSetContinuousAcrossHandles is a option func that sets whether the
+// handles created by the handle constructor should be treated as a single
+// continuous stream. When enabled, data left at the end of a handle without a
+// delimiter is carried over and prepended to the next handle, rather than
+// being emitted as a line of its own. The start offset of a line that begins
+// within a previous handle is negative. Data remaining once the handle
+// constructor returns io.EOF is emitted as a final line, unless the EOF
+// behaviour is "retry", in which case it waits for the next handle.
+func OptLinesSetContinuousAcrossHandles(continuous bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.continuous = continuous
+	}
+}
+
+// OptLinesSetHandleLabeler is a option func that sets a function called with
+// each handle created by the handle constructor, which returns a label that is
+// set as the metadata field `handle` of each message part read from it.
+func OptLinesSetHandleLabeler(labeler func(io.Reader) string) func(r *Lines) {
+	return func(r *Lines) {
+		r.labeler = labeler
+	}
+}
+
+// OptLinesSetGracefulClose is a option func that sets whether CloseAsync
+// should allow the handle currently being read to be consumed until its end,
+// with the remaining lines returned by Read, before onClose is called and the
+// reader closes. The drain is bounded by the timeout given to WaitForClose,
+// after which onClose is called regardless and types.ErrTimeout is returned.
+func OptLinesSetGracefulClose(graceful bool) func(r *Lines) {
+	return func(r *Lines) {
+		r.gracefulClose = graceful
+	}
+}
+
+// OptLinesSetStats is a option func that sets the metrics aggregator used for
+// reporting the number of lines and bytes read.
+func OptLinesSetStats(stats metrics.Type) func(r *Lines) {
+	return func(r *Lines) {
+		r.stats = stats
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (r *Lines) closeHandle() {
+	if r.handle != nil {
+		if closer, ok := r.handle.(io.ReadCloser); ok {
+			closer.Close()
+		}
+		r.handle = nil
+	}
+	// A scan left pending in the background modifies the state of the reader
+	// and so must end before a new handle is scanned. Every handle can be
+	// closed, and closing it interrupts the scan.
+	if r.pendingScan != nil {
+		<-r.pendingScan
+		r.pendingScan = nil
+	}
+	if r.decompressor != nil {
+		r.decompressor.Close()
+		r.decompressor = nil
+	}
+	r.scanner = nil
+	r.pendingMsg = nil
+	r.timeouts.idleClosed = false
+
+	if atomic.SwapInt32(&r.handleOpen, 0) == 1 && r.gracefulClose {
+		select {
+		case <-r.closeChan:
+			r.finishDrain()
+		default:
+		}
+	}
+}
+
+// Connect attempts to establish a new scanner for an io.Reader.
+func (r *Lines) Connect() error {
+	if r.scanner != nil {
+		return nil
+	}
+	r.closeHandle() // Just incase we have an open handle without a scanner.
+
+	if r.gracefulClose {
+		select {
+		case <-r.closeChan:
+			return types.ErrTypeClosed
+		default:
+		}
+	}
+
+	if r.maxMessages > 0 && r.messageCount >= r.maxMessages {
+		return types.ErrTypeClosed
+	}
+
+	var err error
+	r.handle, err = r.handleCtor()
+	if err != nil {
+		if err == io.EOF {
+			if r.eofBehavior == "retry" {
+				r.eofWaiting = true
+				return nil
+			}
+			if len(r.carried) > 0 {
+				// The final handle ended without a delimiter and the data
+				// carried from it is emitted as a line of its own.
+				r.flushCarry = true
+				r.newScanner(bytes.NewReader(nil))
+				return nil
+			}
+			return types.ErrTypeClosed
+		}
+		return err
+	}
+	r.flushCarry = false
+
+	resumeOffset, err := r.offsets.resume(r.handle)
+	if err != nil {
+		r.closeHandle()
+		return err
+	}
+
+	if r.labeler != nil {
+		r.handleLabel = r.labeler(r.handle)
+	}
+	if _, ok := r.handle.(io.Closer); !ok {
+		r.handle = newInterruptReader(r.handle)
+	}
+
+	scanHandle := r.handle
+	if r.decompression != "none" {
+		decompressor := &decompressReader{
+			algorithm: r.decompression,
+			handle:    r.handle,
+		}
+		scanHandle, r.decompressor = decompressor, decompressor
+	}
+	if r.encoding != nil {
+		scanHandle = transform.NewReader(
+			scanHandle, unicode.BOMOverride(r.encoding.NewDecoder()),
+		)
+	}
+
+	if r.stripBOM && resumeOffset == 0 {
+		scanHandle = &bomStripReader{
+			r: scanHandle,
+			onStrip: func() {
+				// Offsets remain relative to the start of the stream.
+				r.scanState.consumed += int64(len(utf8BOM))
+			},
+		}
+	}
+
+	r.newScanner(scanHandle)
+	r.scanState.consumed += resumeOffset
+	return nil
+}
+
+// newScanner begins scanning a handle for lines, prefixed with any data carried
+// over from the previous handle.
+func (r *Lines) newScanner(scanHandle io.Reader) {
+	var carriedLen int64
+	if len(r.carried) > 0 {
+		carriedLen = int64(len(r.carried))
+		scanHandle = io.MultiReader(bytes.NewReader(r.carried), scanHandle)
+		r.carried = nil
+	}
+
+	r.scanner = bufio.NewScanner(scanHandle)
+	r.timeouts.line()
+	atomic.StoreInt32(&r.handleOpen, 1)
+	if r.initialBuffer > 0 {
+		size := r.initialBuffer
+		if size > r.maxBuffer {
+			size = r.maxBuffer
+		}
+		r.scanner.Buffer(make([]byte, size), r.maxBuffer)
+	} else if r.maxBuffer != bufio.MaxScanTokenSize {
+		r.scanner.Buffer([]byte{}, r.maxBuffer)
+	}
+
+	r.handleDelim = r.delimiter
+	if r.delimFunc != nil {
+		if delim := r.delimFunc(); len(delim) > 0 {
+			r.handleDelim = []byte(delim)
+		}
+	}
+
+	r.scanner.Split(r.splitFunc())
+	r.lineNumber = 0
+	if !r.continuous {
+		r.rowHeaders = nil
+	}
+	r.scanState = scanState{consumed: -carriedLen}
+	r.token = lineToken{}
+	atomic.StoreInt64(&r.bytesRead, 0)
+}
+
+//------------------------------------------------------------------------------
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	utf8BOM   = []byte{0xef, 0xbb, 0xbf}
+)
+
+// bomStripReader removes a UTF-8 byte order mark from the start of a stream.
+// Only as many bytes as are needed to rule out a mark are read before the
+// stream is passed through, and therefore reads are not held up waiting for
+// more data than a line requires.
+type bomStripReader struct {
+	r       io.Reader
+	checked bool
+	pending []byte
+	onStrip func()
+}
+
+func (b *bomStripReader) Read(p []byte) (int, error) {
+	if !b.checked {
+		for len(b.pending) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, b.pending) {
+			buf := make([]byte, len(utf8BOM)-len(b.pending))
+			n, err := b.r.Read(buf)
+			b.pending = append(b.pending, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// Bytes read so far are kept for the next attempt.
+				return 0, err
+			}
+		}
+		b.checked = true
+		if bytes.Equal(b.pending, utf8BOM) {
+			b.pending = nil
+			b.onStrip()
+		}
+	}
+	if len(b.pending) > 0 {
+		n := copy(p, b.pending)
+		b.pending = b.pending[n:]
+		return n, nil
+	}
+	return b.r.Read(p)
+}
+
+// interruptReader wraps a handle that cannot be closed so that a blocked read
+// of it can still be interrupted. Each read of the handle is made in the
+// background, and closing the interruptReader ends a blocked read immediately
+// with io.ErrClosedPipe whilst the read of the handle is left to finish on its
+// own.
+type interruptReader struct {
+	r         io.Reader
+	buf       []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type interruptResult struct {
+	n   int
+	err error
+}
+
+func newInterruptReader(r io.Reader) *interruptReader {
+	return &interruptReader{
+		r:      r,
+		closed: make(chan struct{}),
+	}
+}
+
+func (i *interruptReader) Read(p []byte) (int, error) {
+	select {
+	case <-i.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	// The handle reads into a buffer of our own as an interrupted read might
+	// still write to it after we return.
+	if cap(i.buf) < len(p) {
+		i.buf = make([]byte, len(p))
+	}
+	buf := i.buf[:len(p)]
+	resChan := make(chan interruptResult, 1)
+	go func() {
+		n, err := i.r.Read(buf)
+		resChan <- interruptResult{n: n, err: err}
+	}()
+
+	select {
+	case res := <-resChan:
+		return copy(p, buf[:res.n]), res.err
+	case <-i.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (i *interruptReader) Close() error {
+	i.closeOnce.Do(func() {
+		close(i.closed)
+	})
+	return nil
+}
+
+// decompressReader decompresses a handle. The decompressor is created lazily
+// on the first read, and therefore errors from detecting the algorithm or
+// reading stream headers are returned from reads rather than from Connect.
+type decompressReader struct {
+	algorithm string
+	handle    io.Reader
+
+	r      io.Reader
+	closer io.Closer
+}
+
+func (d *decompressReader) init() error {
+	algorithm := d.algorithm
+	buffered := bufio.NewReader(d.handle)
+	if algorithm == "auto" {
+		magic, err := buffered.Peek(len(zstdMagic))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(magic, gzipMagic):
+			algorithm = "gzip"
+		case bytes.HasPrefix(magic, zstdMagic):
+			algorithm = "zstd"
+		default:
+			algorithm = "none"
+		}
+	}
+
+	switch algorithm {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("failed to decompress gzip stream: %v", err)
+		}
+		d.r, d.closer = gzipReader, gzipReader
+	case "zstd":
+		zstdReader := zstd.NewReader(buffered)
+		d.r, d.closer = zstdReader, zstdReader
+	default:
+		d.r = buffered
+	}
+	return nil
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	if d.r == nil {
+		if err := d.init(); err != nil {
+			return 0, err
+		}
+	}
+	return d.r.Read(p)
+}
+
+func (d *decompressReader) Close() error {
+	if d.closer != nil {
+		return d.closer.Close()
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+func (r *Lines) splitFunc() bufio.SplitFunc {
+	split := r.splitDelimiter
+	if r.wholeStream {
+		split = r.splitWholeStream
+	} else if r.customSplit != nil {
+		split = r.customSplit
+	} else if r.delimRegexp != nil {
+		split = r.splitRegexp
+	} else if len(r.delimiters) > 0 {
+		split = r.splitDelimiters
+	}
+	if r.oversizeStrategy != "error" {
+		split = r.splitOversize(split)
+	}
+	split = splitFlushEOF(split)
+	if r.continuous {
+		split = r.splitCarry(split)
+	}
+	return r.splitTrackOffset(split)
+}
+
+// splitCarry wraps a split function in order to carry data that remains
+// unterminated at the end of a handle over to the next handle.
+func (r *Lines) splitCarry(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if !atEOF || r.flushCarry {
+			return split(data, atEOF)
+		}
+		// Only tokens that are terminated within the handle are emitted.
+		advance, token, err := split(data, false)
+		if err != nil || advance > 0 || token != nil || len(data) == 0 {
+			return advance, token, err
+		}
+		r.carried = append([]byte(nil), data...)
+		return len(data), nil, nil
+	}
+}
+
+// splitTrackOffset wraps a split function in order to track the offset of each
+// token within the handle.
+func (r *Lines) splitTrackOffset(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		r.scanState.token.terminated, r.scanState.token.delimIndex = false, -1
+		advance, token, err := split(data, atEOF)
+		if token != nil {
+			r.scanState.token.offset = r.scanState.consumed
+			r.scanState.token.end = r.scanState.consumed + int64(advance)
+		}
+		r.scanState.consumed += int64(advance)
+		atomic.AddInt64(&r.bytesRead, int64(advance))
+		atomic.AddInt64(&r.totalBytesRead, int64(advance))
+		return advance, token, err
+	}
+}
+
+// splitFlushEOF wraps a split function in order to guarantee that any data
+// remaining at the end of a handle is emitted as a final line rather than
+// being dropped when the handle is closed.
+func splitFlushEOF(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if atEOF && err == nil && advance == 0 && token == nil && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return advance, token, err
+	}
+}
+
+// splitOversize wraps a split function in order to catch lines that would fill
+// the scanner buffer before they are terminated. Once a line is caught the
+// remainder of it is discarded until the next delimiter is found.
+func (r *Lines) splitOversize(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if err != nil || advance > 0 || token != nil {
+			if r.scanState.discarding && advance > 0 {
+				// This is the tail end of an oversized line.
+				r.scanState.discarding = false
+				return advance, nil, err
+			}
+			return advance, token, err
+		}
+		if len(data) < r.maxBuffer {
+			return 0, nil, nil
+		}
+
+		// Keep the tail of our buffer when it could be the beginning of a
+		// delimiter that straddles its boundary, so that it is neither
+		// emitted nor missed by the next scan.
+		advance = len(data)
+		if !r.wholeStream && r.customSplit == nil && r.delimRegexp == nil {
+			advance -= r.partialDelimiter(data)
+		}
+		if r.scanState.discarding || r.oversizeStrategy == "skip" {
+			r.scanState.discarding = true
+			return advance, nil, nil
+		}
+		r.scanState.discarding = true
+		r.scanState.token.delimLen = 0
+		return advance, data[:advance], nil
+	}
+}
+
+func (r *Lines) splitDelimiter(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.Index(data, r.handleDelim); i >= 0 {
+		// We have a full terminated line.
+		return r.terminated(data, i, i+len(r.handleDelim))
+	}
+
+	// If we're at EOF, we have a final, non-terminated line. Return it.
+	if atEOF {
+		r.scanState.token.delimLen = 0
+		return len(data), data, nil
+	}
+
+	// Request more data.
+	return 0, nil, nil
+}
+
+// splitWholeStream requests data until the end of the handle and then returns
+// all of it as a single token.
+func (r *Lines) splitWholeStream(data []byte, atEOF bool) (int, []byte, error) {
+	if !atEOF || len(data) == 0 {
+		return 0, nil, nil
+	}
+	r.scanState.token.delimLen = 0
+	return len(data), data, nil
+}
+
+// splitFixedWidth returns a split function that frames data into records of
+// length bytes.
+func splitFixedWidth(length int, emitPartial bool) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) >= length {
+			return length, data[:length], nil
+		}
+		if atEOF && len(data) > 0 {
+			if emitPartial {
+				return len(data), data, nil
+			}
+			// Consuming the partial record prevents it from being flushed
+			// as a final token.
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// delimiterNames are the names given to common delimiters in the `delimiter`
+// metadata field, other delimiters are hex encoded.
+var delimiterNames = map[string]string{
+	"\n":   "lf",
+	"\r\n": "crlf",
+	"\r":   "cr",
+	"\t":   "tab",
+	"\x00": "null",
+}
+
+// delimiterName returns the name of a delimiter for the `delimiter` metadata
+// field.
+func delimiterName(delim []byte) string {
+	if name, exists := delimiterNames[string(delim)]; exists {
+		return name
+	}
+	return hex.EncodeToString(delim)
+}
+
+// partialDelimiter returns the length of the longest tail of data that is
+// the beginning of a delimiter, not including complete delimiters.
+func (r *Lines) partialDelimiter(data []byte) int {
+	delims := r.delimiters
+	if len(delims) == 0 {
+		delims = [][]byte{r.handleDelim}
+	}
+	longest := 0
+	for _, delim := range delims {
+		for n := len(delim) - 1; n > longest; n-- {
+			if bytes.HasSuffix(data, delim[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+func (r *Lines) splitDelimiters(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	start, end, matched := -1, -1, -1
+	for j, delim := range r.delimiters {
+		if len(delim) == 0 {
+			continue
+		}
+		i := bytes.Index(data, delim)
+		if i < 0 {
+			continue
+		}
+		if start < 0 || i < start || (i == start && i+len(delim) > end) {
+			start, end, matched = i, i+len(delim), j
+		}
+	}
+
+	if start >= 0 {
+		if !atEOF {
+			// A longer delimiter might match at the same position once more
+			// data arrives, e.g. \r\n when only \r has been read.
+			for _, delim := range r.delimiters {
+				if len(delim) > end-start && len(data)-start < len(delim) &&
+					bytes.HasPrefix(delim, data[start:]) {
+					return 0, nil, nil
+				}
+			}
+		}
+		r.scanState.token.delimIndex = matched
+		return r.terminated(data, start, end)
+	}
+
+	if atEOF {
+		r.scanState.token.delimLen = 0
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func (r *Lines) splitRegexp(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if loc, start, end := r.matchDelimiter(data); loc != nil {
+		// A match that runs up to the end of our buffer might extend further
+		// once more data arrives, so unless we're at EOF we wait for more.
+		if loc[1] < len(data) || atEOF {
+			return r.terminated(data, start, end)
+		}
+		return 0, nil, nil
+	}
+
+	if atEOF {
+		r.scanState.token.delimLen = 0
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// matchDelimiter returns the first match of the delimiter expression within
+// data that contains a delimiter of non-zero length, along with the range of
+// that delimiter. Matches with an empty delimiter are skipped as they would
+// never advance the scan.
+func (r *Lines) matchDelimiter(data []byte) (loc []int, start, end int) {
+	delimRange := func(loc []int) (int, int) {
+		if len(loc) > 2 && loc[2] >= 0 {
+			return loc[2], loc[3]
+		}
+		return loc[0], loc[1]
+	}
+	if loc = r.delimRegexp.FindSubmatchIndex(data); loc == nil {
+		return nil, 0, 0
+	}
+	if start, end = delimRange(loc); end > start {
+		return loc, start, end
+	}
+	for _, loc = range r.delimRegexp.FindAllSubmatchIndex(data, -1) {
+		if start, end = delimRange(loc); end > start {
+			return loc, start, end
+		}
+	}
+	return nil, 0, 0
+}
+
+// terminated returns the token of a line terminated by a delimiter found
+// within data at the range [start, end).
+func (r *Lines) terminated(data []byte, start, end int) (int, []byte, error) {
+	r.scanState.token.terminated = true
+	if r.keepDelimiter {
+		r.scanState.token.delimLen = end - start
+		return end, data[0:end], nil
+	}
+	r.scanState.token.delimLen = 0
+	return end, data[0:start], nil
+}
+
+//------------------------------------------------------------------------------
+
+// Read attempts to read a new line from the io.Reader.
+func (r *Lines) Read() (types.Message, error) {
+	return r.ReadWithContext(context.Background())
+}
+
+// ReadWithContext attempts to read a new line from the io.Reader, returning
+// the error of the context if it is cancelled before a line is read. Lines
+// that are partially read when the context is cancelled are not lost, and are
+// returned by a subsequent call.
+func (r *Lines) ReadWithContext(ctx context.Context) (types.Message, error) {
+	if r.requeued != nil {
+		msg := r.requeued
+		r.requeued = nil
+		r.trackParts(msg)
+		return msg, nil
+	}
+
+	readCtx, done := r.timeouts.readContext(ctx)
+	defer done()
+
+	r.timeouts.beating = false
+	err := r.waitForAccess(readCtx)
+	var msg types.Message
+	if err == nil {
+		msg, err = r.readMessage(readCtx)
+	}
+	if err != nil {
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			return nil, types.ErrTimeout
+		}
+		return nil, err
+	}
+	if r.timeouts.beating {
+		// Heartbeats are neither tracked nor counted.
+		return msg, nil
+	}
+	r.trackParts(msg)
+	r.offsets.read()
+	r.mPartCount.Set(int64(msg.Len()))
+	r.batch.begin()
+	if r.maxMessages > 0 {
+		if r.messageCount++; r.messageCount >= r.maxMessages {
+			r.closeHandle()
+		}
+	}
+	// Only lines that have been read count toward the limit, as a scan left
+	// pending might have consumed more of the handle.
+	if r.maxHandleLen > 0 && r.token.end >= r.maxHandleLen {
+		r.closeHandle()
+	}
+	return msg, nil
+}
+
+// waitForAccess blocks until the rate limit, if any, grants access to read a
+// message.
+func (r *Lines) waitForAccess(ctx context.Context) error {
+	if r.rateLimit == nil || r.pendingMsg != nil {
+		return nil
+	}
+	for {
+		waitFor, err := r.rateLimit.Access()
+		if err == types.ErrTypeClosed {
+			return err
+		}
+		if err != nil {
+			waitFor = time.Second
+		}
+		if waitFor <= 0 {
+			return nil
+		}
+		select {
+		case <-time.After(waitFor):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.drained:
+			return types.ErrTypeClosed
+		}
+	}
+}
+
+// parseCSV parses a line, excluding any kept delimiter, as a single CSV record.
+func (r *Lines) parseCSV(token []byte) ([]string, error) {
+	cr := csv.NewReader(bytes.NewReader(token[:len(token)-r.token.delimLen]))
+	cr.Comma = r.csvComma
+	cr.FieldsPerRecord = -1
+
+	record, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = cr.Read(); err != io.EOF {
+		if err == nil {
+			err = errors.New("line contains more than one record")
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// csvObject serialises a record as a JSON object keyed by the headers, or by
+// position where headers are absent, followed by the delimiter when kept.
+func (r *Lines) csvObject(record []string, delim []byte) []byte {
+	headers := r.csvHeaders
+	if len(headers) == 0 {
+		headers = r.rowHeaders
+	}
+	obj := make(map[string]string, len(record))
+	for i, field := range record {
+		key := "col" + strconv.Itoa(i)
+		if i < len(headers) {
+			key = headers[i]
+		}
+		obj[key] = field
+	}
+	objBytes, _ := json.Marshal(obj)
+	return append(objBytes, delim...)
+}
+
+// decodeLine decodes a line with the line decoder. When delimiters are kept the
+// delimiter is not decoded and is appended to the decoded line.
+func (r *Lines) decodeLine(token []byte) ([]byte, error) {
+	content, delim := token[:len(token)-r.token.delimLen], token[len(token)-r.token.delimLen:]
+
+	var decoded []byte
+	var err error
+	switch r.lineDecoder {
+	case "base64":
+		decoded = make([]byte, base64.StdEncoding.DecodedLen(len(content)))
+		var n int
+		n, err = base64.StdEncoding.Decode(decoded, content)
+		decoded = decoded[:n]
+	case "base64url":
+		decoded = make([]byte, base64.URLEncoding.DecodedLen(len(content)))
+		var n int
+		n, err = base64.URLEncoding.Decode(decoded, content)
+		decoded = decoded[:n]
+	case "hex":
+		decoded = make([]byte, hex.DecodedLen(len(content)))
+		_, err = hex.Decode(decoded, content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(decoded, delim...), nil
+}
+
+// transformLine applies the line transform to a line. When delimiters are kept
+// the delimiter is not transformed and is appended to the transformed line.
+func (r *Lines) transformLine(token []byte) ([]byte, error) {
+	content, delim := token[:len(token)-r.token.delimLen], token[len(token)-r.token.delimLen:]
+	transformed, err := r.lineTransform(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(delim) == 0 {
+		return transformed, nil
+	}
+	result := make([]byte, 0, len(transformed)+len(delim))
+	result = append(result, transformed...)
+	return append(result, delim...), nil
+}
+
+// trackParts records the parts of a message that has been read so that they
+// can be requeued after a partial failure.
+func (r *Lines) trackParts(msg types.Message) {
+	msg.Iter(func(_ int, p types.Part) error {
+		r.unackedParts = append(r.unackedParts, p)
+		return nil
+	})
+}
+
+// lineToken describes a token produced by the split function of a scanner.
+type lineToken struct {
+	// The offset of the token within the handle and the offset at which it
+	// ends, including its delimiter.
+	offset int64
+	end    int64
+
+	// The length of any delimiter kept at the end of the token, whether the
+	// token was terminated by a delimiter and, when there are several, the
+	// index of the delimiter that matched.
+	delimLen   int
+	terminated bool
+	delimIndex int
+}
+
+// scanState is the state of a scan that is modified by the split function of
+// the scanner.
+type scanState struct {
+	token      lineToken
+	consumed   int64
+	discarding bool
+}
+
+// scanResult is handed over by a scan once it stops.
+type scanResult struct {
+	ok    bool
+	token lineToken
+}
+
+// scan advances the scanner to the next token. If the context has a deadline
+// or can be cancelled then the scan is performed in the background, and if the
+// context ends first the scan is left pending for the next call.
+func (r *Lines) scan(ctx context.Context) (bool, error) {
+	if r.pendingScan == nil {
+		if ctx.Done() == nil {
+			res := r.scanToken(r.scanner)
+			r.token = res.token
+			return res.ok, nil
+		}
+		scanner, resChan := r.scanner, make(chan scanResult, 1)
+		go func() {
+			resChan <- r.scanToken(scanner)
+		}()
+		r.pendingScan = resChan
+	}
+	select {
+	case res := <-r.pendingScan:
+		r.pendingScan = nil
+		r.token = res.token
+		return res.ok, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// scanToken advances a scanner to its next token, which is described by the
+// state of the scan once the scanner stops.
+func (r *Lines) scanToken(scanner *bufio.Scanner) scanResult {
+	ok := scanner.Scan()
+	return scanResult{ok: ok, token: r.scanState.token}
+}
+
+// closeIdle closes a handle that has gone without producing a line for longer
+// than the idle timeout, which interrupts a pending scan of it. The scanner is
+// kept so that lines it has already read are not lost, and is discarded once
+// it stops.
+func (r *Lines) closeIdle() {
+	if closer, ok := r.handle.(io.Closer); ok {
+		closer.Close()
+	}
+	r.handle = nil
+	r.timeouts.idleClosed = true
+}
+
+// retryHandle waits for the EOF retry interval and then attempts to open a
+// new handle, returning types.ErrTimeout if the handle constructor returns
+// io.EOF again.
+func (r *Lines) retryHandle(ctx context.Context) error {
+	select {
+	case <-time.After(r.eofRetryInterval):
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.closeChan:
+		return types.ErrTypeClosed
+	}
+	r.eofWaiting = false
+	if err := r.Connect(); err != nil {
+		return err
+	}
+	if r.eofWaiting {
+		return types.ErrTimeout
+	}
+	return nil
+}
+
+// linesBufferPool holds the buffers used for the contents of message parts by
+// Lines readers with pooled buffers enabled.
+var linesBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// pooledCopy copies a line into a buffer from the pool, which is owned by the
+// resulting message part alone until it is returned to the pool when the part
+// is acknowledged.
+func (r *Lines) pooledCopy(token []byte) []byte {
+	bufPtr := linesBufferPool.Get().(*[]byte)
+	buf := append((*bufPtr)[:0], token...)
+	*bufPtr = buf
+	r.pooledBufs = append(r.pooledBufs, bufPtr)
+	return buf[:len(buf):len(buf)]
+}
+
+// releasePooled returns the buffers of all acknowledged message parts to the
+// pool.
+func (r *Lines) releasePooled() {
+	for i, bufPtr := range r.pooledBufs {
+		linesBufferPool.Put(bufPtr)
+		r.pooledBufs[i] = nil
+	}
+	r.pooledBufs = r.pooledBufs[:0]
+}
+
+// bufferedCopy writes a line to the message buffer of the reader and returns
+// the slice of the buffer that it occupies.
+func (r *Lines) bufferedCopy(token []byte) ([]byte, error) {
+	partSize, err := r.messageBuffer.Write(token)
+	rIndex := r.messageBufferIndex
+	r.messageBufferIndex += partSize
+	if err != nil {
+		return nil, err
+	}
+
+	// WARNING: According to https://golang.org/pkg/bytes/#Buffer.Bytes the
+	// slice returned by Bytes is only correct until the next call to Write.
+	// Since we call Write for multiple part messages, and could potentially
+	// call it on a consecutive Read call before the next Acknowledge, we
+	// are passing slices through messages that are "invalid".
+	//
+	// However, in practice the calls to Write do not overwrite the memory
+	// within the returned slice even if it results in the buffer
+	// re-allocating memory. Since we also ensure that Reset is only called
+	// once messages are no longer in use we should be fine here.
+	//
+	// Regardless, we should regularly revisit this code in order to ensure
+	// that this remains the case. I can't foresee any case where discarded
+	// slices within bytes.Buffer would be wiped or modified during Write,
+	// but since the library does not guarantee this:
+	//
+	// TODO: Have another cheeky gander at
+	// https://golang.org/src/bytes/buffer.go to make sure Write never
+	// mutates a discarded slice during re-allocation. If it does then we
+	// should stop using bytes.Buffer and either eat the allocations or do
+	// some buffer rotations of our own.
+	//
+	// Enabling pooled buffers avoids this aliasing entirely as each line is
+	// copied into a buffer of its own.
+	return r.messageBuffer.Bytes()[rIndex : rIndex+partSize : rIndex+partSize], nil
+}
+
+func (r *Lines) readMessage(ctx context.Context) (types.Message, error) {
+	if r.scanner == nil {
+		if !r.eofWaiting {
+			return nil, types.ErrNotConnected
+		}
+		if err := r.retryHandle(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	msg := r.pendingMsg
+	if msg == nil {
+		msg = message.New(nil)
+	}
+	r.pendingMsg = nil
+
+	for {
+		batchCtx, batchDone := ctx, func() {}
+		if !r.multipart {
+			batchCtx, batchDone = r.batch.context(ctx, msg.Len())
+		}
+		scanCtx, scanDone := r.timeouts.scanContext(batchCtx, r.handle != nil)
+		ok, err := r.scan(scanCtx)
+		scanDone()
+		batchDone()
+		if err != nil {
+			if ctx.Err() == nil && r.timeouts.idleElapsed() {
+				r.closeIdle()
+				continue
+			}
+			if ctx.Err() == nil && r.timeouts.beatDue() {
+				if msg.Len() > 0 {
+					if !r.multipart {
+						return msg, nil
+					}
+					r.pendingMsg = msg
+				}
+				r.timeouts.beat()
+				beat := message.New(nil)
+				beat.Append(message.NewPart(nil))
+				beat.Get(0).Metadata().Set("heartbeat", "true")
+				return beat, nil
+			}
+			if ctx.Err() == nil {
+				// The batch period has elapsed and the scan is left pending
+				// for the next batch.
+				return msg, nil
+			}
+			if msg.Len() > 0 {
+				r.pendingMsg = msg
+			}
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		r.lineNumber++
+		r.timeouts.line()
+		token := r.scanner.Bytes()
+
+		var delimName string
+		if r.token.delimIndex >= 0 {
+			delimName = delimiterName(r.delimiters[r.token.delimIndex])
+		}
+
+		var lineEnding string
+		if r.trimCR {
+			lineEnding = "none"
+			if r.token.terminated {
+				lineEnding = "lf"
+				if bytes.HasSuffix(token[:len(token)-r.token.delimLen], []byte("\r")) {
+					lineEnding = "crlf"
+				}
+			}
+		}
+
+		var decodeErr error
+		if r.lineTransform != nil {
+			var transformed []byte
+			if transformed, decodeErr = r.transformLine(token); decodeErr == nil {
+				token = transformed
+			} else if r.decodeErrorStrategy == "error" {
+				if msg.Len() > 0 {
+					r.pendingMsg = msg
+				}
+				return nil, &ReadError{
+					Err: fmt.Errorf("failed to transform line %v: %v", r.lineNumber, decodeErr),
+				}
+			}
+		}
+
+		if r.multipart && r.terminator != nil {
+			if bytes.Equal(token[:len(token)-r.token.delimLen], r.terminator) {
+				if msg.Len() > 0 {
+					return msg, nil
+				}
+				continue
+			}
+			if r.collapse && len(token) == r.token.delimLen {
+				continue
+			}
+		} else if len(token) == r.token.delimLen {
+			if r.multipart && !r.collapse && msg.Len() > 0 {
+				// Empty line means we're finished reading parts for this
+				// message.
+				return msg, nil
+			}
+			if r.multipart || !r.emitEmpty {
+				continue
+			}
+		}
+
+		if r.filter != nil && !r.filter(token[:len(token)-r.token.delimLen]) {
+			continue
+		}
+
+		if r.lineDecoder != "none" && decodeErr == nil {
+			var decoded []byte
+			if decoded, decodeErr = r.decodeLine(token); decodeErr == nil {
+				token = decoded
+			} else if r.decodeErrorStrategy == "error" {
+				if msg.Len() > 0 {
+					r.pendingMsg = msg
+				}
+				return nil, &ReadError{
+					Err: fmt.Errorf("failed to decode line %v: %v", r.lineNumber, decodeErr),
+				}
+			}
+		}
+
+		if r.csv && decodeErr == nil {
+			var record []string
+			if record, decodeErr = r.parseCSV(token); decodeErr == nil {
+				if len(r.csvHeaders) == 0 && r.csvHeaderRow && r.rowHeaders == nil {
+					r.rowHeaders = record
+					continue
+				}
+				token = r.csvObject(record, token[len(token)-r.token.delimLen:])
+			} else if r.decodeErrorStrategy == "error" {
+				if msg.Len() > 0 {
+					r.pendingMsg = msg
+				}
+				return nil, &ReadError{
+					Err: fmt.Errorf("failed to parse line %v as csv: %v", r.lineNumber, decodeErr),
+				}
+			}
+		}
+
+		var key string
+		if r.keyExtractor != nil && decodeErr == nil {
+			content := token[:len(token)-r.token.delimLen]
+			if key, decodeErr = r.keyExtractor(content); decodeErr != nil && r.decodeErrorStrategy == "error" {
+				if msg.Len() > 0 {
+					r.pendingMsg = msg
+				}
+				return nil, &ReadError{
+					Err: fmt.Errorf("failed to extract key from line %v: %v", r.lineNumber, decodeErr),
+				}
+			}
+		}
+
+		jsonValid := true
+		if r.validateJSON {
+			jsonValid = json.Valid(token[:len(token)-r.token.delimLen])
+			if !jsonValid && r.invalidJSONStrategy == "drop" {
+				continue
+			}
+		}
+
+		var partBytes []byte
+		if r.pooled {
+			partBytes = r.pooledCopy(token)
+		} else if partBytes, err = r.bufferedCopy(token); err != nil {
+			return nil, err
+		}
+
+		part := message.NewPart(partBytes)
+		part.Metadata().Set("line_number", strconv.Itoa(r.lineNumber))
+		part.Metadata().Set("start_offset", strconv.FormatInt(r.token.offset, 10))
+		part.Metadata().Set("end_offset", strconv.FormatInt(r.token.end, 10))
+		if r.labeler != nil {
+			part.Metadata().Set("handle", r.handleLabel)
+		}
+		if len(lineEnding) > 0 {
+			part.Metadata().Set("line_ending", lineEnding)
+		}
+		if len(delimName) > 0 {
+			part.Metadata().Set("delimiter", delimName)
+		}
+		if decodeErr != nil {
+			part.Metadata().Set("decode_error", decodeErr.Error())
+		} else if r.keyExtractor != nil {
+			part.Metadata().Set("key", key)
+		}
+		if !jsonValid {
+			part.Metadata().Set("json_valid", "false")
+		}
+		msg.Append(part)
+		r.offsets.added(r.token.end)
+		r.mRcvd.Incr(1)
+		r.mBytes.Incr(int64(len(partBytes)))
+		if !r.multipart && r.batch.ready(msg.Len()) {
+			return msg, nil
+		}
+	}
+
+	// Reading from a handle closed for being idle fails as expected.
+	if err := r.scanner.Err(); err != nil && !r.timeouts.idleClosed {
+		r.closeHandle()
+		return nil, classifyReadError(err)
+	}
+
+	r.closeHandle()
+
+	if msg.Len() > 0 {
+		if r.multipart {
+			// The handle ended before the message was terminated.
+			msg.Iter(func(i int, p types.Part) error {
+				p.Metadata().Set("multipart_incomplete", "true")
+				return nil
+			})
+		}
+		return msg, nil
+	}
+	return nil, types.ErrNotConnected
+}
+
+// BytesRead returns the number of bytes of the current handle that have been
+// consumed by the scanner, which can be compared with the size of the handle in
+// order to report progress. Bytes are counted after decompression and decoding
+// of the handle. It is safe to call concurrently with reads.
+func (r *Lines) BytesRead() int64 {
+	return atomic.LoadInt64(&r.bytesRead)
+}
+
+// TotalBytesRead returns the number of bytes consumed by the scanner across
+// all handles that have been read. It is safe to call concurrently with reads.
+func (r *Lines) TotalBytesRead() int64 {
+	return atomic.LoadInt64(&r.totalBytesRead)
+}
+
+// Acknowledge confirms whether or not our unacknowledged messages have been
+// successfully propagated or not. If the error is a PartError then the parts
+// that failed are read again as a single message by the next call to Read,
+// whereas resending the message after any other error is left to the caller.
+func (r *Lines) Acknowledge(err error) error {
+	if r.timeouts.beating {
+		r.timeouts.beating = false
+		return nil
+	}
+	if err == nil {
+		if r.messageBuffer != nil {
+			r.messageBuffer.Reset()
+			r.messageBufferIndex = 0
+		}
+		r.releasePooled()
+		r.unackedParts = nil
+		return r.offsets.acknowledged()
+	}
+	if pErr, ok := err.(PartError); ok {
+		// The message buffer is not reset until a successful acknowledgement
+		// and therefore the contents of the failed parts remain valid.
+		msg := message.New(nil)
+		for _, i := range pErr.FailedParts() {
+			if i >= 0 && i < len(r.unackedParts) {
+				msg.Append(r.unackedParts[i].Copy())
+			}
+		}
+		if msg.Len() > 0 {
+			r.requeued = msg
+		}
+	}
+	// Part indexes of a later error refer to the parts read after this one.
+	r.unackedParts = nil
+	return nil
+}
+
+// CloseAsync shuts down the reader input and stops processing requests.
+func (r *Lines) CloseAsync() {
+	r.closeOnce.Do(func() {
+		close(r.closeChan)
+	})
+	if !r.gracefulClose {
+		r.drainOnce.Do(func() {
+			close(r.drained)
+		})
+		r.onClose()
+		return
+	}
+	if atomic.LoadInt32(&r.handleOpen) == 0 {
+		r.finishDrain()
+	}
+	// Otherwise the drain is finished once the open handle is closed.
+}
+
+// finishDrain ends a graceful close, unblocking any remaining reads.
+func (r *Lines) finishDrain() {
+	r.drainOnce.Do(func() {
+		close(r.drained)
+		r.onClose()
+	})
+}
+
+// WaitForClose blocks until the reader input has closed down.
+func (r *Lines) WaitForClose(timeout time.Duration) error {
+	if r.gracefulClose {
+		select {
+		case <-r.drained:
+		case <-time.After(timeout):
+			r.finishDrain()
+			return types.ErrTimeout
+		}
+	}
 	r.closeHandle()
 	return nil
 }
@@ -0,0 +1,64 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"context"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+// lineBatch decides when the lines read by a Lines reader in single part mode
+// are returned as a message, which is once a count of lines is reached or once
+// a period has elapsed since the previous message was returned.
+type lineBatch struct {
+	count  int
+	period time.Duration
+	start  time.Time
+}
+
+// ready returns whether a message of n lines is ready to be returned.
+func (b *lineBatch) ready(n int) bool {
+	if b.count <= 0 && b.period <= 0 {
+		return true
+	}
+	if b.count > 0 && n >= b.count {
+		return true
+	}
+	return b.period > 0 && time.Since(b.start) >= b.period
+}
+
+// context returns a context that ends once the period of a message of n lines
+// has elapsed. A message without lines is not returned and so has no deadline.
+func (b *lineBatch) context(ctx context.Context, n int) (context.Context, func()) {
+	if b.period <= 0 || n == 0 {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, b.start.Add(b.period))
+}
+
+// begin starts the period of the next message.
+func (b *lineBatch) begin() {
+	b.start = time.Now()
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+//------------------------------------------------------------------------------
+
+// lineOffsets tracks the offset following the lines read by a Lines reader so
+// that it can be written to an offset store once they are acknowledged.
+type lineOffsets struct {
+	store   OffsetStore
+	resumed bool
+
+	// The end of the last line added to a message, which is the offset of the
+	// message rather than the position of the scanner as a scan left pending
+	// might have moved on, and the offset of the most recently read message,
+	// which is held until it is acknowledged.
+	msgEnd  int64
+	unacked int64
+	pending bool
+}
+
+// resume seeks the first handle to be opened to the stored offset and returns
+// it, or returns zero for any other handle.
+func (o *lineOffsets) resume(handle io.Reader) (int64, error) {
+	if o.store == nil || o.resumed {
+		return 0, nil
+	}
+	o.resumed = true
+	offset := o.store.Load()
+	if offset <= 0 {
+		return 0, nil
+	}
+	seeker, ok := handle.(io.Seeker)
+	if !ok {
+		return 0, errors.New("handle does not support seeking to the stored offset")
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek to stored offset %v: %v", offset, err)
+	}
+	return offset, nil
+}
+
+// added records the end of a line that has been added to a message.
+func (o *lineOffsets) added(end int64) {
+	o.msgEnd = end
+}
+
+// read holds the offset of a message that has been read until it is
+// acknowledged.
+func (o *lineOffsets) read() {
+	o.unacked, o.pending = o.msgEnd, true
+}
+
+// acknowledged writes the offset of the messages read since the last
+// acknowledgement to the store.
+func (o *lineOffsets) acknowledged() error {
+	if o.store == nil || !o.pending {
+		return nil
+	}
+	o.pending = false
+	return o.store.Store(o.unacked)
+}
+
+//------------------------------------------------------------------------------
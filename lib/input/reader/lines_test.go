@@ -21,12 +21,27 @@
 package reader
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
 	"github.com/Jeffail/benthos/v3/lib/types"
+	"golang.org/x/text/encoding/unicode"
 )
 
 func TestReaderSinglePart(t *testing.T) {
@@ -558,3 +573,3109 @@ func TestReaderMultiPartCustomDelim(t *testing.T) {
 		t.Errorf("Wrong error returned: %v != %v", err, types.ErrTypeClosed)
 	}
 }
+
+func readAllLines(t *testing.T, handle io.Reader, options ...func(*Lines)) [][]string {
+	t.Helper()
+	return readAllLinesHandles(t, []io.Reader{handle}, options...)
+}
+
+func readAllLinesHandles(t *testing.T, handles []io.Reader, options ...func(*Lines)) [][]string {
+	t.Helper()
+
+	f, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			handle := handles[0]
+			handles = handles[1:]
+			return handle, nil
+		},
+		func() {},
+		options...,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		f.CloseAsync()
+		if err := f.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if err = f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var result [][]string
+	for {
+		resMsg, err := f.Read()
+		if err == types.ErrNotConnected {
+			if err = f.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parts []string
+		for _, p := range message.GetAllBytes(resMsg) {
+			parts = append(parts, string(p))
+		}
+		result = append(result, parts)
+		if err = f.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = f.Read(); err != types.ErrNotConnected {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+	return result
+}
+
+func TestReaderNullDelimited(t *testing.T) {
+	tests := map[string]struct {
+		input     string
+		multipart bool
+		exp       [][]string
+	}{
+		"no trailing delimiter": {
+			input: "foo\x00bar\x00baz",
+			exp:   [][]string{{"foo"}, {"bar"}, {"baz"}},
+		},
+		"trailing delimiter": {
+			input: "foo\x00bar\x00baz\x00",
+			exp:   [][]string{{"foo"}, {"bar"}, {"baz"}},
+		},
+		"contains newlines": {
+			input: "foo\nbar\x00baz\n\x00",
+			exp:   [][]string{{"foo\nbar"}, {"baz\n"}},
+		},
+		"empty input": {
+			input: "",
+			exp:   nil,
+		},
+		"only delimiter": {
+			input: "\x00",
+			exp:   nil,
+		},
+		"multipart trailing delimiter": {
+			input:     "foo\x00bar\x00\x00baz\x00",
+			multipart: true,
+			exp:       [][]string{{"foo", "bar"}, {"baz"}},
+		},
+	}
+
+	for name, test := range tests {
+		act := readAllLines(
+			t, bytes.NewBufferString(test.input),
+			OptLinesSetNullDelimited(),
+			OptLinesSetMultipart(test.multipart),
+		)
+		if !reflect.DeepEqual(act, test.exp) {
+			t.Errorf("%v: wrong result: %q != %q", name, act, test.exp)
+		}
+	}
+}
+
+func TestReaderRegexpDelim(t *testing.T) {
+	input := "2019-01-01 first\nmessage\n2019-01-02 second message\n2019-01-03 third"
+	exp := [][]string{
+		{"2019-01-01 first\nmessage"},
+		{"2019-01-02 second message"},
+		{"2019-01-03 third"},
+	}
+
+	re := regexp.MustCompile(`(\n)\d{4}-\d{2}-\d{2}`)
+	if act := readAllLines(t, bytes.NewBufferString(input), OptLinesSetDelimiterRegexp(re)); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+	if act := readAllLines(t, iotest.OneByteReader(bytes.NewBufferString(input)), OptLinesSetDelimiterRegexp(re)); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	// Expressions that also match the empty string must still make progress.
+	for _, test := range []struct {
+		pattern string
+		input   string
+	}{
+		{pattern: `\n*`, input: "first\n\nsecond\nthird"},
+		{pattern: `(,?)`, input: "first,second,third"},
+	} {
+		re = regexp.MustCompile(test.pattern)
+		exp = [][]string{{"first"}, {"second"}, {"third"}}
+		if act := readAllLines(t, bytes.NewBufferString(test.input), OptLinesSetDelimiterRegexp(re)); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for %v: %q != %q", test.pattern, act, exp)
+		}
+		if act := readAllLines(t, iotest.OneByteReader(bytes.NewBufferString(test.input)), OptLinesSetDelimiterRegexp(re)); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for %v: %q != %q", test.pattern, act, exp)
+		}
+	}
+
+	// Feed the reader a byte at a time to ensure we never split on a partial
+	// match at the end of the buffer.
+	re = regexp.MustCompile(`\n+`)
+	input = "first\n\n\nsecond\nthird\n\n"
+	exp = [][]string{{"first"}, {"second"}, {"third"}}
+	if act := readAllLines(t, iotest.OneByteReader(bytes.NewBufferString(input)), OptLinesSetDelimiterRegexp(re)); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderOversizeStrategy(t *testing.T) {
+	input := "short\nthis line is far too long\nafter\n"
+
+	exp := [][]string{{"short"}, {"this line "}, {"after"}}
+	act := readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetMaxBuffer(10),
+		OptLinesSetOversizeStrategy("truncate"),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"short"}, {"after"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetMaxBuffer(10),
+		OptLinesSetOversizeStrategy("skip"),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"short"}, {"after"}}
+	act = readAllLines(
+		t, bytes.NewBufferString("short<FOO>this line is far too long<FOO>after"),
+		OptLinesSetMaxBuffer(10),
+		OptLinesSetDelimiter("<FOO>"),
+		OptLinesSetOversizeStrategy("skip"),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	// A delimiter that straddles the limit must not be emitted in part, nor
+	// be missed by the following scan.
+	exp = [][]string{{"short"}, {"0123456"}, {"after"}}
+	act = readAllLines(
+		t, bytes.NewBufferString("short<FOO>0123456<FOxyz<FOO>after"),
+		OptLinesSetMaxBuffer(10),
+		OptLinesSetDelimiter("<FOO>"),
+		OptLinesSetOversizeStrategy("truncate"),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"short"}, {"0123456"}, {"after"}}
+	act = readAllLines(
+		t, bytes.NewBufferString("short<FOO>0123456<FOO>after"),
+		OptLinesSetMaxBuffer(10),
+		OptLinesSetDelimiter("<FOO>"),
+		OptLinesSetOversizeStrategy("truncate"),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	if _, err := NewLines(nil, nil, OptLinesSetOversizeStrategy("nope")); err == nil {
+		t.Error("Expected error from bad strategy")
+	}
+}
+
+func TestReaderInitialBuffer(t *testing.T) {
+	input := "short\nthis line is far too long\nafter\n"
+
+	exp := [][]string{{"short"}, {"this line is far too long"}, {"after"}}
+	act := readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetInitialBuffer(64),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	// The initial buffer must not raise the limit set by the max buffer.
+	exp = [][]string{{"short"}, {"this line "}, {"after"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetInitialBuffer(64),
+		OptLinesSetMaxBuffer(10),
+		OptLinesSetOversizeStrategy("truncate"),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderStats(t *testing.T) {
+	stats := metrics.NewLocal()
+
+	exp := [][]string{{"foo", "bar"}, {"baz"}}
+	act := readAllLines(
+		t, bytes.NewBufferString("foo\nbar\n\nbaz\n"),
+		OptLinesSetMultipart(true),
+		OptLinesSetStats(stats),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	expCounters := map[string]int64{
+		"lines.received":   3,
+		"lines.bytes":      9,
+		"lines.part_count": 1,
+	}
+	if actCounters := stats.GetCounters(); !reflect.DeepEqual(actCounters, expCounters) {
+		t.Errorf("Wrong counters: %v != %v", actCounters, expCounters)
+	}
+}
+
+func TestReaderKeepDelimiter(t *testing.T) {
+	input := "foo\nbar\n\nbaz"
+
+	exp := [][]string{{"foo\n"}, {"bar\n"}, {"baz"}}
+	act := readAllLines(t, bytes.NewBufferString(input), OptLinesKeepDelimiter(true))
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo\n", "bar\n"}, {"baz"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesKeepDelimiter(true),
+		OptLinesSetMultipart(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	re := regexp.MustCompile(`[;,]`)
+	exp = [][]string{{"foo;"}, {"bar,"}, {"baz"}}
+	act = readAllLines(
+		t, bytes.NewBufferString("foo;bar,baz"),
+		OptLinesKeepDelimiter(true),
+		OptLinesSetDelimiterRegexp(re),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderCustomSplit(t *testing.T) {
+	var handle bytes.Buffer
+	for _, part := range []string{"foo", "bar", "", "baz"} {
+		binary.Write(&handle, binary.BigEndian, uint32(len(part)))
+		handle.WriteString(part)
+	}
+
+	split := func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) < 4 {
+			if atEOF && len(data) > 0 {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			return 0, nil, nil
+		}
+		l := int(binary.BigEndian.Uint32(data))
+		if len(data) < 4+l {
+			if atEOF {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			return 0, nil, nil
+		}
+		return 4 + l, data[4 : 4+l], nil
+	}
+
+	exp := [][]string{{"foo", "bar"}, {"baz"}}
+	act := readAllLines(
+		t, &handle,
+		OptLinesSetSplitFunc(bufio.SplitFunc(split)),
+		OptLinesSetMultipart(true),
+		OptLinesSetDelimiter("a"),
+		OptLinesKeepDelimiter(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderFlushOnRotation(t *testing.T) {
+	exp := [][]string{{"foo"}, {"bar"}, {"baz"}, {"qux"}}
+	act := readAllLinesHandles(t, []io.Reader{
+		bytes.NewBufferString("foo\nbar"),
+		bytes.NewBufferString("baz\nqux"),
+	})
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	// A custom split function that only ever emits terminated lines.
+	split := func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	act = readAllLinesHandles(t, []io.Reader{
+		bytes.NewBufferString("foo\nbar"),
+		bytes.NewBufferString("baz\nqux"),
+	}, OptLinesSetSplitFunc(split))
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderMaxMessages(t *testing.T) {
+	exp := [][]string{{"foo"}, {"bar"}}
+	act := readAllLinesHandles(t, []io.Reader{
+		bytes.NewBufferString("foo\n"),
+		bytes.NewBufferString("bar\nbaz\nqux\n"),
+	}, OptLinesSetMaxMessages(2))
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo", "bar"}}
+	act = readAllLines(
+		t, bytes.NewBufferString("foo\nbar\n\nbaz\n"),
+		OptLinesSetMaxMessages(1),
+		OptLinesSetMultipart(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderMultipartTerminator(t *testing.T) {
+	input := "foo\n\nbar\n---END---\n---END---\nbaz\n---END---\nqux"
+
+	exp := [][]string{{"foo", "", "bar"}, {"baz"}, {"qux"}}
+	act := readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetMultipart(true),
+		OptLinesSetMultipartTerminator([]byte("---END---")),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo\n", "\n", "bar\n"}, {"baz\n"}, {"qux"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetMultipart(true),
+		OptLinesSetMultipartTerminator([]byte("---END---")),
+		OptLinesKeepDelimiter(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderReadWithContext(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	r, err := NewLines(
+		func() (io.Reader, error) { return pr, nil },
+		func() {},
+		OptLinesSetMultipart(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	readCtx := func() (types.Message, error) {
+		ctx, done := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer done()
+		return r.ReadWithContext(ctx)
+	}
+
+	if _, err = readCtx(); err != context.DeadlineExceeded {
+		t.Fatalf("Wrong error returned: %v != %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		pw.Write([]byte("foo\n"))
+	}()
+	if _, err = readCtx(); err != context.DeadlineExceeded {
+		t.Fatalf("Wrong error returned: %v != %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		pw.Write([]byte("bar\n\n"))
+	}()
+	msg, err := readCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("foo"), []byte("bar")}, message.GetAllBytes(msg); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReaderReadWithContextReconnect(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	handles := []io.Reader{pr, bytes.NewBufferString("bar\n")}
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			h := handles[0]
+			handles = handles[1:]
+			return h, nil
+		},
+		func() {},
+		OptLinesSetIdleTimeout(time.Millisecond*100),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		pw.Write([]byte("foo\n"))
+	}()
+
+	// Reads time out whilst the scan of the first handle remains pending,
+	// until the handle is closed for being idle and the next is scanned.
+	var act []string
+	timeouts := 0
+	for {
+		ctx, done := context.WithTimeout(context.Background(), time.Millisecond*20)
+		msg, err := r.ReadWithContext(ctx)
+		done()
+		if err == context.DeadlineExceeded {
+			timeouts++
+			continue
+		}
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			act = append(act, "reconnected")
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if exp := []string{"foo", "reconnected", "bar"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+	if timeouts == 0 {
+		t.Error("Expected reads to time out")
+	}
+}
+
+func TestReaderPlainHandleClose(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	// The handle hides the Close method of the pipe and its reads block.
+	r, err := NewLines(
+		func() (io.Reader, error) { return struct{ io.Reader }{pr}, nil },
+		func() {},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, done := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer done()
+	if _, err = r.ReadWithContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wrong error returned: %v != %v", err, context.DeadlineExceeded)
+	}
+
+	// The pending scan is interrupted rather than waited for.
+	closed := make(chan error)
+	go func() {
+		r.CloseAsync()
+		closed <- r.WaitForClose(time.Second)
+	}()
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for reader to close")
+	}
+}
+
+func TestReaderEncoding(t *testing.T) {
+	utf16LE := func(s string) []byte {
+		b, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := map[string]struct {
+		input []byte
+		opts  []func(*Lines)
+	}{
+		"no encoding": {
+			input: []byte("foo\nbär\n"),
+		},
+		"utf-8 bom": {
+			input: append([]byte{0xef, 0xbb, 0xbf}, "foo\nbär\n"...),
+			opts:  []func(*Lines){OptLinesSetEncoding(unicode.UTF8)},
+		},
+		"utf-16le": {
+			input: utf16LE("foo\nbär\n"),
+			opts:  []func(*Lines){OptLinesSetEncoding(unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM))},
+		},
+		"utf-16le bom": {
+			input: append([]byte{0xff, 0xfe}, utf16LE("foo\nbär\n")...),
+			opts:  []func(*Lines){OptLinesSetEncoding(unicode.UTF8)},
+		},
+	}
+
+	for name, test := range tests {
+		exp := [][]string{{"foo"}, {"bär"}}
+		if act := readAllLines(t, bytes.NewReader(test.input), test.opts...); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for '%v': %q != %q", name, act, exp)
+		}
+	}
+}
+
+func TestReaderBatchCount(t *testing.T) {
+	exp := [][]string{{"foo", "bar"}, {"baz", "buz"}, {"qux"}}
+	act := readAllLines(
+		t, bytes.NewReader([]byte("foo\nbar\nbaz\nbuz\nqux\n")),
+		OptLinesSetBatchCount(2),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderBatchPeriod(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	r, err := NewLines(
+		func() (io.Reader, error) { return pr, nil },
+		func() {},
+		OptLinesSetBatchCount(10),
+		OptLinesSetBatchPeriod(time.Millisecond*50),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		pw.Write([]byte("foo\nbar\n"))
+	}()
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("foo"), []byte("bar")}, message.GetAllBytes(msg); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+
+	go func() {
+		pw.Write([]byte("baz\n"))
+	}()
+
+	if msg, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("baz")}, message.GetAllBytes(msg); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+}
+
+func TestReaderBatchPeriodPendingScan(t *testing.T) {
+	pr, pw := io.Pipe()
+
+	// Lines arrive slower than the batch period, and so reads regularly
+	// return while a scan of the handle is still pending.
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if pr == nil {
+				return nil, io.EOF
+			}
+			h := pr
+			pr = nil
+			return h, nil
+		},
+		func() {},
+		OptLinesSetBatchCount(10),
+		OptLinesSetBatchPeriod(time.Millisecond*5),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var exp []string
+	for i := 0; i < 20; i++ {
+		exp = append(exp, fmt.Sprintf("line%v", i))
+	}
+	go func() {
+		for _, line := range exp {
+			pw.Write([]byte(line + "\n"))
+			<-time.After(time.Millisecond * 2)
+		}
+		pw.Close()
+	}()
+
+	var act []string
+	var end int64
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Iter(func(_ int, p types.Part) error {
+			act = append(act, string(p.Get()))
+			if start := p.Metadata().Get("start_offset"); start != strconv.FormatInt(end, 10) {
+				t.Errorf("Wrong start_offset of %s: %v != %v", p.Get(), start, end)
+			}
+			end += int64(len(p.Get())) + 1
+			if act := p.Metadata().Get("end_offset"); act != strconv.FormatInt(end, 10) {
+				t.Errorf("Wrong end_offset of %s: %v != %v", p.Get(), act, end)
+			}
+			return nil
+		})
+		if err = r.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+type testPartError []int
+
+func (e testPartError) Error() string {
+	return "parts failed"
+}
+
+func (e testPartError) FailedParts() []int {
+	return e
+}
+
+func TestReaderPartError(t *testing.T) {
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewReader([]byte("foo\nbar\nbaz\n\nqux\n")), nil
+		},
+		func() {},
+		OptLinesSetMultipart(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	readMsg := func() [][]byte {
+		t.Helper()
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message.GetAllBytes(msg)
+	}
+
+	if exp, act := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}, readMsg(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if err = r.Acknowledge(testPartError{0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("foo"), []byte("baz")}, readMsg(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if err = r.Acknowledge(testPartError{1}); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("baz")}, readMsg(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("qux")}, readMsg(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+}
+
+func TestReaderPartErrorAfterError(t *testing.T) {
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewReader([]byte("foo\nbar\n\nbaz\n\n")), nil
+		},
+		func() {},
+		OptLinesSetMultipart(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	readMsg := func() [][]byte {
+		t.Helper()
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message.GetAllBytes(msg)
+	}
+
+	if exp, act := [][]byte{[]byte("foo"), []byte("bar")}, readMsg(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if err = r.Acknowledge(errors.New("nope")); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("baz")}, readMsg(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+	if err = r.Acknowledge(testPartError{0}); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := [][]byte{[]byte("baz")}, readMsg(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong result: %s != %s", act, exp)
+	}
+}
+
+func TestReaderDecompression(t *testing.T) {
+	var gzBuf bytes.Buffer
+	gzWtr := gzip.NewWriter(&gzBuf)
+	gzWtr.Write([]byte("foo\nbar\n"))
+	gzWtr.Close()
+
+	tests := map[string]struct {
+		input     []byte
+		algorithm string
+	}{
+		"gzip":           {gzBuf.Bytes(), "gzip"},
+		"auto gzip":      {gzBuf.Bytes(), "auto"},
+		"auto none":      {[]byte("foo\nbar\n"), "auto"},
+		"auto too small": {[]byte("foo"), "auto"},
+	}
+
+	for name, test := range tests {
+		exp := [][]string{{"foo"}, {"bar"}}
+		if name == "auto too small" {
+			exp = [][]string{{"foo"}}
+		}
+		act := readAllLines(t, bytes.NewReader(test.input), OptLinesSetDecompression(test.algorithm))
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for '%v': %q != %q", name, act, exp)
+		}
+	}
+}
+
+func TestReaderDecompressionError(t *testing.T) {
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewReader([]byte("not compressed\n")), nil
+		},
+		func() {},
+		OptLinesSetDecompression("gzip"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err == nil {
+		t.Error("Expected error from corrupt stream")
+	}
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+
+	if _, err = NewLines(nil, func() {}, OptLinesSetDecompression("nope")); err == nil {
+		t.Error("Expected error from bad algorithm")
+	}
+}
+
+func TestReaderReadTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	r, err := NewLines(
+		func() (io.Reader, error) { return pr, nil },
+		func() {},
+		OptLinesSetReadTimeout(time.Millisecond*50),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		pw.Write([]byte("fo"))
+	}()
+	if _, err = r.Read(); err != types.ErrTimeout {
+		t.Fatalf("Wrong error returned: %v != %v", err, types.ErrTimeout)
+	}
+
+	go func() {
+		pw.Write([]byte("o\n"))
+	}()
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+type mockRateLimit struct {
+	waits []time.Duration
+	calls int
+}
+
+func (m *mockRateLimit) Access() (time.Duration, error) {
+	m.calls++
+	if len(m.waits) == 0 {
+		return 0, nil
+	}
+	wait := m.waits[0]
+	m.waits = m.waits[1:]
+	return wait, nil
+}
+
+func (m *mockRateLimit) CloseAsync() {}
+
+func (m *mockRateLimit) WaitForClose(time.Duration) error {
+	return nil
+}
+
+func TestReaderRateLimit(t *testing.T) {
+	rl := &mockRateLimit{
+		waits: []time.Duration{0, time.Millisecond * 50},
+	}
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewReader([]byte("foo\nbar\n")), nil
+		},
+		func() {},
+		OptLinesSetRateLimit(rl),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{"foo", "bar"} {
+		start := time.Now()
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act := string(msg.Get(0).Get()); act != exp {
+			t.Errorf("Wrong message: %v != %v", act, exp)
+		}
+		if exp == "bar" && time.Since(start) < time.Millisecond*50 {
+			t.Error("Expected read to be rate limited")
+		}
+	}
+	if exp, act := 3, rl.calls; exp != act {
+		t.Errorf("Wrong count of rate limit accesses: %v != %v", act, exp)
+	}
+
+	rl.waits = []time.Duration{time.Hour}
+	ctx, done := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer done()
+	if _, err = r.ReadWithContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wrong error: %v != %v", err, context.DeadlineExceeded)
+	}
+
+	rl.waits = []time.Duration{time.Hour}
+	go func() {
+		<-time.After(time.Millisecond * 10)
+		r.CloseAsync()
+	}()
+	if _, err = r.Read(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestReaderLineDecoder(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		opts  []func(*Lines)
+		exp   [][]string
+	}{
+		"base64": {
+			input: "Zm9v\nYmFy\n",
+			opts:  []func(*Lines){OptLinesSetLineDecoder("base64")},
+			exp:   [][]string{{"foo"}, {"bar"}},
+		},
+		"base64url": {
+			input: "-_8=\n",
+			opts:  []func(*Lines){OptLinesSetLineDecoder("base64url")},
+			exp:   [][]string{{"\xfb\xff"}},
+		},
+		"hex": {
+			input: "666f6f\n626172\n",
+			opts:  []func(*Lines){OptLinesSetLineDecoder("hex")},
+			exp:   [][]string{{"foo"}, {"bar"}},
+		},
+		"hex keep delimiter": {
+			input: "666f6f\n626172",
+			opts: []func(*Lines){
+				OptLinesSetLineDecoder("hex"),
+				OptLinesKeepDelimiter(true),
+			},
+			exp: [][]string{{"foo\n"}, {"bar"}},
+		},
+		"passthrough": {
+			input: "Zm9v\nnot base64\n",
+			opts: []func(*Lines){
+				OptLinesSetLineDecoder("base64"),
+				OptLinesSetDecodeErrorStrategy("passthrough"),
+			},
+			exp: [][]string{{"foo"}, {"not base64"}},
+		},
+	}
+
+	for name, test := range tests {
+		if act := readAllLines(t, bytes.NewReader([]byte(test.input)), test.opts...); !reflect.DeepEqual(act, test.exp) {
+			t.Errorf("Wrong result for '%v': %q != %q", name, act, test.exp)
+		}
+	}
+}
+
+func TestReaderLineDecoderErrors(t *testing.T) {
+	for _, strategy := range []string{"error", "passthrough"} {
+		r, err := NewLines(
+			func() (io.Reader, error) {
+				return bytes.NewReader([]byte("nope\n666f6f\n")), nil
+			},
+			func() {},
+			OptLinesSetLineDecoder("hex"),
+			OptLinesSetDecodeErrorStrategy(strategy),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := r.Read()
+		if strategy == "error" {
+			if err == nil {
+				t.Error("Expected error from bad line")
+			}
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp, act := "nope", string(msg.Get(0).Get()); exp != act {
+				t.Errorf("Wrong result: %v != %v", act, exp)
+			}
+			if len(msg.Get(0).Metadata().Get("decode_error")) == 0 {
+				t.Error("Expected decode_error metadata")
+			}
+		}
+
+		if msg, err = r.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if exp, act := "foo", string(msg.Get(0).Get()); exp != act {
+			t.Errorf("Wrong result: %v != %v", act, exp)
+		}
+		if len(msg.Get(0).Metadata().Get("decode_error")) > 0 {
+			t.Error("Unexpected decode_error metadata")
+		}
+	}
+}
+
+func TestReaderLineNumberMetadata(t *testing.T) {
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewReader([]byte("foo\nbar\n\nbaz\n")), nil
+		},
+		func() {},
+		OptLinesSetMultipart(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range [][]string{{"1", "2"}, {"4"}} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var act []string
+		msg.Iter(func(i int, p types.Part) error {
+			act = append(act, p.Metadata().Get("line_number"))
+			return nil
+		})
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong line numbers: %v != %v", act, exp)
+		}
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReaderOffsetMetadata(t *testing.T) {
+	tests := map[string]struct {
+		input   string
+		options []func(*Lines)
+		exp     [][2]string
+	}{
+		"single byte delimiter": {
+			input: "foo\nbar\n\nbaz",
+			exp:   [][2]string{{"0", "4"}, {"4", "8"}, {"9", "12"}},
+		},
+		"multiple byte delimiter": {
+			input:   "foo<D>barbaz<D>",
+			options: []func(*Lines){OptLinesSetDelimiter("<D>")},
+			exp:     [][2]string{{"0", "6"}, {"6", "15"}},
+		},
+		"keep delimiter": {
+			input:   "foo\nbar\n",
+			options: []func(*Lines){OptLinesKeepDelimiter(true)},
+			exp:     [][2]string{{"0", "4"}, {"4", "8"}},
+		},
+	}
+
+	for name, test := range tests {
+		input := test.input
+		r, err := NewLines(
+			func() (io.Reader, error) {
+				return bytes.NewReader([]byte(input)), nil
+			},
+			func() {},
+			test.options...,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		var act [][2]string
+		for range test.exp {
+			msg, err := r.Read()
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			act = append(act, [2]string{
+				msg.Get(0).Metadata().Get("start_offset"),
+				msg.Get(0).Metadata().Get("end_offset"),
+			})
+			if err = r.Acknowledge(nil); err != nil {
+				t.Error(err)
+			}
+		}
+		if !reflect.DeepEqual(act, test.exp) {
+			t.Errorf("%v: wrong offsets: %v != %v", name, act, test.exp)
+		}
+	}
+}
+
+func TestReaderMultipartIncomplete(t *testing.T) {
+	tests := map[string]struct {
+		input   string
+		options []func(*Lines)
+		exp     []string
+	}{
+		"empty line terminated": {
+			input: "foo\nbar\n\nbaz\n",
+			exp:   []string{"", "true"},
+		},
+		"trailing empty line": {
+			input: "foo\nbar\n\nbaz\n\n",
+			exp:   []string{"", ""},
+		},
+		"custom terminator": {
+			input:   "foo\nEND\nbar\n",
+			options: []func(*Lines){OptLinesSetMultipartTerminator([]byte("END"))},
+			exp:     []string{"", "true"},
+		},
+	}
+
+	for name, test := range tests {
+		input := test.input
+		r, err := NewLines(
+			func() (io.Reader, error) {
+				return bytes.NewReader([]byte(input)), nil
+			},
+			func() {},
+			append([]func(*Lines){OptLinesSetMultipart(true)}, test.options...)...,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		var act []string
+		for range test.exp {
+			msg, err := r.Read()
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			act = append(act, msg.Get(-1).Metadata().Get("multipart_incomplete"))
+			if err = r.Acknowledge(nil); err != nil {
+				t.Error(err)
+			}
+		}
+		if !reflect.DeepEqual(act, test.exp) {
+			t.Errorf("%v: wrong flags: %q != %q", name, act, test.exp)
+		}
+	}
+}
+
+func TestReaderValidateJSON(t *testing.T) {
+	input := `{"foo":"bar"}` + "\n" + `{"foo":` + "\n" + `[1,2,3]` + "\n" + `nope` + "\n"
+
+	exp := [][]string{{`{"foo":"bar"}`}, {`[1,2,3]`}}
+	act := readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetValidateJSON(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString(input), nil
+		},
+		func() {},
+		OptLinesSetValidateJSON(true),
+		OptLinesSetInvalidJSONStrategy("tag"),
+		OptLinesKeepDelimiter(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range [][2]string{
+		{`{"foo":"bar"}` + "\n", ""},
+		{`{"foo":` + "\n", "false"},
+		{`[1,2,3]` + "\n", ""},
+		{"nope\n", "false"},
+	} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		act := [2]string{string(msg.Get(0).Get()), msg.Get(0).Metadata().Get("json_valid")}
+		if act != exp {
+			t.Errorf("Wrong result: %q != %q", act, exp)
+		}
+	}
+
+	if _, err = NewLines(nil, nil, OptLinesSetInvalidJSONStrategy("nope")); err == nil {
+		t.Error("Expected error from bad strategy")
+	}
+}
+
+func TestReaderTrimCR(t *testing.T) {
+	input := "foo\r\nbar\r\n\r\nbaz\r\n"
+
+	exp := [][]string{{"foo"}, {"bar"}, {"baz"}}
+	act := readAllLines(t, bytes.NewBufferString(input), OptLinesTrimCR())
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo", "bar"}, {"baz"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesTrimCR(),
+		OptLinesSetMultipart(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo\n"}, {"bar\n"}, {"baz\n"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesTrimCR(),
+		OptLinesKeepDelimiter(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderLineTransformErrors(t *testing.T) {
+	transform := OptLinesSetLineTransform(func(line []byte) ([]byte, error) {
+		if bytes.Equal(line, []byte("bad")) {
+			return nil, errors.New("bad line")
+		}
+		return bytes.ToUpper(line), nil
+	})
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString("foo\nbad\nbar\n"), nil
+		},
+		func() {},
+		transform,
+		OptLinesSetDecodeErrorStrategy("passthrough"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range [][2]string{
+		{"FOO", ""},
+		{"bad", "bad line"},
+		{"BAR", ""},
+	} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		act := [2]string{string(msg.Get(0).Get()), msg.Get(0).Metadata().Get("decode_error")}
+		if act != exp {
+			t.Errorf("Wrong result: %q != %q", act, exp)
+		}
+	}
+
+	r, err = NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString("foo\nbad\nbar\n"), nil
+		},
+		func() {},
+		transform,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err == nil {
+		t.Error("Expected error from transform")
+	}
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "BAR", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestReaderHandleDelimiter(t *testing.T) {
+	handles := []struct {
+		content, delim string
+	}{
+		{"foo\nbar\n", ""},
+		{"baz\x1equx\x1e", "\x1e"},
+		{"quz,qix", ","},
+	}
+
+	i := -1
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if i++; i >= len(handles) {
+				return nil, io.EOF
+			}
+			return bytes.NewBufferString(handles[i].content), nil
+		},
+		func() {},
+		OptLinesSetHandleDelimiter(func() string {
+			return handles[i].delim
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"foo", "bar", "baz", "qux", "quz", "qix"}
+	var act []string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderEOFRetry(t *testing.T) {
+	handles := []string{"", "", "foo\n", ""}
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			content := handles[0]
+			handles = handles[1:]
+			if len(content) == 0 {
+				return nil, io.EOF
+			}
+			return bytes.NewBufferString(content), nil
+		},
+		func() {},
+		OptLinesSetEOFBehavior("retry"),
+		OptLinesSetEOFRetryInterval(time.Millisecond*10),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err != types.ErrTimeout {
+		t.Fatalf("Wrong error: %v != %v", err, types.ErrTimeout)
+	}
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "foo", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+
+	// The handle is exhausted and the next is empty.
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Fatalf("Wrong error: %v != %v", err, types.ErrNotConnected)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		<-time.After(time.Millisecond * 50)
+		r.CloseAsync()
+	}()
+	for {
+		if _, err = r.Read(); err != types.ErrTimeout {
+			break
+		}
+	}
+	if err != types.ErrTypeClosed {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrTypeClosed)
+	}
+	if err = r.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReaderBadEOFBehavior(t *testing.T) {
+	if _, err := NewLines(
+		func() (io.Reader, error) {
+			return nil, io.EOF
+		},
+		func() {},
+		OptLinesSetEOFBehavior("nope"),
+	); err == nil {
+		t.Error("Expected error from bad eof behaviour")
+	}
+}
+
+func TestReaderMultipleDelimiters(t *testing.T) {
+	input := "foo\r\nbar\nbaz\x1equx\r\n"
+	delims := OptLinesSetDelimiters([][]byte{
+		[]byte("\n"), []byte("\r\n"), []byte("\r"), []byte("\x1e"),
+	})
+
+	exp := [][]string{{"foo"}, {"bar"}, {"baz"}, {"qux"}}
+	act := readAllLines(t, bytes.NewBufferString(input), delims)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	// Delimiters that straddle reads are still matched in full.
+	act = readAllLines(t, iotest.OneByteReader(bytes.NewBufferString(input)), delims)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo\r\n"}, {"bar\n"}, {"baz\x1e"}, {"qux\r\n"}}
+	act = readAllLines(
+		t, iotest.OneByteReader(bytes.NewBufferString(input)),
+		delims, OptLinesKeepDelimiter(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderStripBOM(t *testing.T) {
+	bom := "\xef\xbb\xbf"
+	input := bom + "{\"foo\":1}\n" + bom + "{\"bar\":2}\n"
+
+	exp := [][]string{{"{\"foo\":1}"}, {bom + "{\"bar\":2}"}}
+	for name, handle := range map[string]io.Reader{
+		"buffer":   bytes.NewBufferString(input),
+		"one byte": iotest.OneByteReader(bytes.NewBufferString(input)),
+	} {
+		if act := readAllLines(t, handle, OptLinesStripBOM(true)); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result for '%v': %q != %q", name, act, exp)
+		}
+	}
+
+	// Each handle is stripped.
+	exp = [][]string{{"foo"}, {"bar"}}
+	act := readAllLinesHandles(t, []io.Reader{
+		bytes.NewBufferString(bom + "foo\n"),
+		bytes.NewBufferString(bom + "bar\n"),
+	}, OptLinesStripBOM(true))
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	// Streams without a mark, or shorter than a mark, are unchanged.
+	exp = [][]string{{"\xef\xbb"}}
+	act = readAllLines(t, bytes.NewBufferString("\xef\xbb"), OptLinesStripBOM(true))
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo"}, {"bär"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(bom+"foo\nbär\n"),
+		OptLinesStripBOM(true), OptLinesSetEncoding(unicode.UTF8),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderStripBOMOffsets(t *testing.T) {
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString("\xef\xbb\xbffoo\nbar\n"), nil
+		},
+		func() {},
+		OptLinesStripBOM(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range [][2]string{{"3", "7"}, {"7", "11"}} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := msg.Get(0).Metadata()
+		if act := [2]string{meta.Get("start_offset"), meta.Get("end_offset")}; act != exp {
+			t.Errorf("Wrong offsets: %v != %v", act, exp)
+		}
+	}
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "timed out" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+type errReader struct {
+	content []byte
+	err     error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	if len(e.content) > 0 {
+		n := copy(p, e.content)
+		e.content = e.content[n:]
+		return n, nil
+	}
+	return 0, e.err
+}
+
+func TestReaderReadErrorClassification(t *testing.T) {
+	tests := map[string]struct {
+		handle    io.Reader
+		opts      []func(*Lines)
+		retryable bool
+	}{
+		"too long": {
+			handle: bytes.NewBufferString("foo bar baz\n"),
+			opts:   []func(*Lines){OptLinesSetMaxBuffer(5)},
+		},
+		"timeout": {
+			handle:    &errReader{err: timeoutErr{}},
+			retryable: true,
+		},
+		"wrapped timeout": {
+			handle:    &errReader{err: &os.PathError{Op: "read", Path: "foo", Err: timeoutErr{}}},
+			retryable: true,
+		},
+		"other": {
+			handle: &errReader{err: errors.New("disk on fire")},
+		},
+		"decode": {
+			handle: bytes.NewBufferString("not hex\n"),
+			opts:   []func(*Lines){OptLinesSetLineDecoder("hex")},
+		},
+	}
+
+	for name, test := range tests {
+		handle := test.handle
+		r, err := NewLines(
+			func() (io.Reader, error) {
+				return handle, nil
+			},
+			func() {},
+			test.opts...,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Read()
+		var rErr *ReadError
+		if !errors.As(err, &rErr) {
+			t.Errorf("%v: expected read error, received: %v", name, err)
+			continue
+		}
+		if rErr.Retryable != test.retryable {
+			t.Errorf("%v: wrong retryable: %v != %v", name, rErr.Retryable, test.retryable)
+		}
+	}
+}
+
+func TestReaderPooledBuffers(t *testing.T) {
+	input := "foo\nbar\n\nbaz\nqux\n\n"
+
+	exp := [][]string{{"foo"}, {"bar"}, {"baz"}, {"qux"}}
+	act := readAllLines(t, bytes.NewBufferString(input), OptLinesSetPooledBuffers(true))
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo", "bar"}, {"baz", "qux"}}
+	act = readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetPooledBuffers(true),
+		OptLinesSetMultipart(true),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderPooledBuffersRelease(t *testing.T) {
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString("foo\nbar\nbaz\n"), nil
+		},
+		func() {},
+		OptLinesSetPooledBuffers(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []types.Message
+	for i := 0; i < 2; i++ {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	// Buffers are kept until a successful acknowledgement.
+	if err = r.Acknowledge(testPartError{1}); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := 2, len(r.pooledBufs); exp != act {
+		t.Errorf("Wrong count of pooled buffers: %v != %v", act, exp)
+	}
+	for i, exp := range []string{"foo", "bar"} {
+		if act := string(msgs[i].Get(0).Get()); act != exp {
+			t.Errorf("Wrong result: %v != %v", act, exp)
+		}
+	}
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "bar", string(msg.Get(0).Get()); act != exp {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+
+	if err = r.Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.pooledBufs) > 0 {
+		t.Errorf("Expected pooled buffers to be released: %v", len(r.pooledBufs))
+	}
+}
+
+func benchmarkLinesRead(b *testing.B, ackEvery int, options ...func(*Lines)) {
+	var input bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		input.WriteString(`{"id":"`)
+		input.WriteString(strconv.Itoa(i))
+		input.WriteString(`","content":"hello world, this is a line of moderate length"}`)
+		input.WriteByte('\n')
+	}
+	data := input.Bytes()
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewReader(data), nil
+		},
+		func() {},
+		options...,
+	)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err != nil {
+				b.Fatal(err)
+			}
+			_, err = r.Read()
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		if (i+1)%ackEvery == 0 {
+			if err = r.Acknowledge(nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkLinesRead(b *testing.B) {
+	benchmarkLinesRead(b, 1)
+}
+
+func BenchmarkLinesReadPooled(b *testing.B) {
+	benchmarkLinesRead(b, 1, OptLinesSetPooledBuffers(true))
+}
+
+func BenchmarkLinesReadDelayedAck(b *testing.B) {
+	benchmarkLinesRead(b, 1000)
+}
+
+func BenchmarkLinesReadDelayedAckPooled(b *testing.B) {
+	benchmarkLinesRead(b, 1000, OptLinesSetPooledBuffers(true))
+}
+
+func TestReaderMaxBytesPerHandle(t *testing.T) {
+	// Each handle resumes from where the previous one was closed.
+	content := "foo\nbar\nbaz\nqux\nquz\n"
+	offset := 0
+	var handles int
+
+	var consumed *Lines
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if consumed != nil {
+				offset += int(consumed.token.end)
+			}
+			if offset >= len(content) {
+				return nil, io.EOF
+			}
+			handles++
+			return bytes.NewBufferString(content[offset:]), nil
+		},
+		func() {},
+		OptLinesSetMaxBytesPerHandle(6),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumed = r
+
+	exp := []string{"foo", "bar", "baz", "qux", "quz"}
+	var act []string
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+	if exp, act := 3, handles; exp != act {
+		t.Errorf("Wrong count of handles: %v != %v", act, exp)
+	}
+}
+
+func TestReaderMaxBytesPerHandlePendingScan(t *testing.T) {
+	var content string
+	var exp []string
+	for i := 0; i < 20; i++ {
+		line := fmt.Sprintf("line%v", i)
+		exp = append(exp, line)
+		content += line + "\n"
+	}
+
+	// Lines arrive slower than the batch period, and so handles reach their
+	// limit while a scan is still pending. Each handle resumes from the end of
+	// the last line read from the previous one.
+	offset := 0
+	var consumed *Lines
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if consumed != nil {
+				offset += int(consumed.token.end)
+			}
+			if offset >= len(content) {
+				return nil, io.EOF
+			}
+			pr, pw := io.Pipe()
+			go func(lines []string) {
+				for _, line := range lines {
+					if _, err := pw.Write([]byte(line)); err != nil {
+						return
+					}
+					<-time.After(time.Millisecond * 2)
+				}
+				pw.Close()
+			}(strings.SplitAfter(content[offset:], "\n"))
+			return pr, nil
+		},
+		func() {},
+		OptLinesSetBatchCount(10),
+		OptLinesSetBatchPeriod(time.Millisecond*5),
+		OptLinesSetMaxBytesPerHandle(20),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumed = r
+
+	var act []string
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Iter(func(_ int, p types.Part) error {
+			act = append(act, string(p.Get()))
+			return nil
+		})
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderFilter(t *testing.T) {
+	content := "foo error\nbar\nbaz error\r\nerror\n"
+
+	tests := map[string]struct {
+		options []func(*Lines)
+		exp     [][]string
+	}{
+		"substring": {
+			options: []func(*Lines){OptLinesSetFilter(LinesContaining("error"))},
+			exp:     [][]string{{"foo error"}, {"baz error\r"}, {"error"}},
+		},
+		"regexp": {
+			options: []func(*Lines){OptLinesSetFilter(LinesMatching(regexp.MustCompile(`^ba`)))},
+			exp:     [][]string{{"bar"}, {"baz error\r"}},
+		},
+		"after transform": {
+			options: []func(*Lines){
+				OptLinesTrimCR(),
+				OptLinesSetFilter(LinesMatching(regexp.MustCompile(`error$`))),
+			},
+			exp: [][]string{{"foo error"}, {"baz error"}, {"error"}},
+		},
+		"delimiter kept": {
+			options: []func(*Lines){
+				OptLinesKeepDelimiter(true),
+				OptLinesSetFilter(LinesMatching(regexp.MustCompile(`^[a-z]+$`))),
+			},
+			exp: [][]string{{"bar\n"}, {"error\n"}},
+		},
+		"multipart": {
+			options: []func(*Lines){
+				OptLinesSetMultipart(true),
+				OptLinesSetFilter(LinesContaining("ba")),
+			},
+			exp: [][]string{{"bar", "baz error\r"}},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			act := readAllLines(t, bytes.NewBufferString(content), test.options...)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Errorf("Wrong result: %q != %q", act, test.exp)
+			}
+		})
+	}
+}
+
+func TestReaderGracefulClose(t *testing.T) {
+	var closed int
+	handles := []io.Reader{
+		bytes.NewBufferString("foo\nbar\nbaz\n"),
+		bytes.NewBufferString("qux\n"),
+	}
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			h := handles[0]
+			handles = handles[1:]
+			return h, nil
+		},
+		func() {
+			closed++
+		},
+		OptLinesSetGracefulClose(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := []string{string(msg.Get(0).Get())}
+
+	r.CloseAsync()
+	if closed != 0 {
+		t.Error("Expected onClose to wait for the drain")
+	}
+	for {
+		msg, err = r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err != types.ErrTypeClosed {
+				t.Fatalf("Expected closed, received: %v", err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+	}
+	if exp := []string{"foo", "bar", "baz"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+	if err = r.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+	if closed != 1 {
+		t.Errorf("Expected onClose to be called once, called %v times", closed)
+	}
+}
+
+func TestReaderGracefulCloseTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return pr, nil
+		},
+		func() {
+			pw.Close()
+		},
+		OptLinesSetGracefulClose(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		pw.Write([]byte("foo\n"))
+	}()
+	if _, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	readErr := make(chan error)
+	go func() {
+		_, err := r.Read()
+		readErr <- err
+	}()
+
+	r.CloseAsync()
+	if err = r.WaitForClose(time.Millisecond * 50); err != types.ErrTimeout {
+		t.Errorf("Expected timeout, received: %v", err)
+	}
+	select {
+	case err = <-readErr:
+		if err != types.ErrNotConnected {
+			t.Errorf("Wrong error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected blocked read to be unblocked")
+	}
+}
+
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func TestReaderHandleLabeler(t *testing.T) {
+	handles := []io.Reader{
+		namedReader{Reader: bytes.NewBufferString("foo\nbar\n"), name: "first"},
+		namedReader{Reader: bytes.NewBufferString("baz\n"), name: "second"},
+	}
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			h := handles[0]
+			handles = handles[1:]
+			return h, nil
+		},
+		func() {},
+		OptLinesSetHandleLabeler(func(h io.Reader) string {
+			return h.(namedReader).name
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var act []string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := msg.Get(0)
+		act = append(act, string(p.Get())+":"+p.Metadata().Get("handle"))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if exp := []string{"foo:first", "bar:first", "baz:second"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderContinuousAcrossHandles(t *testing.T) {
+	newHandles := func() []io.Reader {
+		return []io.Reader{
+			bytes.NewBufferString("foo\nba"),
+			bytes.NewBufferString("r\nb"),
+			bytes.NewBufferString(""),
+			bytes.NewBufferString("az\nqux"),
+		}
+	}
+
+	exp := [][]string{{"foo"}, {"bar"}, {"baz"}, {"qux"}}
+	act := readAllLinesHandles(t, newHandles(), OptLinesSetContinuousAcrossHandles(true))
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	exp = [][]string{{"foo"}, {"ba"}, {"r"}, {"b"}, {"az"}, {"qux"}}
+	act = readAllLinesHandles(t, newHandles())
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderContinuousAcrossHandlesOffsets(t *testing.T) {
+	handles := []io.Reader{
+		bytes.NewBufferString("foo\nba"),
+		bytes.NewBufferString("r\nbaz\n"),
+	}
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			h := handles[0]
+			handles = handles[1:]
+			return h, nil
+		},
+		func() {},
+		OptLinesSetContinuousAcrossHandles(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var act []string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := msg.Get(0).Metadata()
+		act = append(act, fmt.Sprintf(
+			"%s:%v-%v", msg.Get(0).Get(), meta.Get("start_offset"), meta.Get("end_offset"),
+		))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if exp := []string{"foo:0-4", "bar:-2-2", "baz:2-6"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestReaderAdaptiveBuffer(t *testing.T) {
+	input := "short\n" + strings.Repeat("x", 100) + "\nafter\n"
+
+	exp := [][]string{{"short"}, {strings.Repeat("x", 100)}, {"after"}}
+	act := readAllLines(
+		t, bytes.NewBufferString(input),
+		OptLinesSetAdaptiveBuffer(8, 128),
+	)
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString(input), nil
+		},
+		func() {},
+		OptLinesSetAdaptiveBuffer(8, 64),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("Expected too long error, received: %v", err)
+	}
+}
+
+func TestReaderIdleTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	handles := []io.Reader{pr, bytes.NewBufferString("baz\n")}
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			h := handles[0]
+			handles = handles[1:]
+			return h, nil
+		},
+		func() {},
+		OptLinesSetIdleTimeout(time.Millisecond*100),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		pw.Write([]byte("foo\n"))
+		<-time.After(time.Millisecond * 50)
+		pw.Write([]byte("bar\n"))
+	}()
+
+	var act []string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			act = append(act, "reconnected")
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if exp := []string{"foo", "bar", "reconnected", "baz"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+	if _, err = pw.Write([]byte("qux\n")); err != io.ErrClosedPipe {
+		t.Errorf("Expected idle handle to be closed, received: %v", err)
+	}
+}
+
+func TestReaderIdleTimeoutPlainHandle(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	// The handle hides the Close method of the pipe, but a blocked read from
+	// it is still abandoned once it has been idle.
+	handles := []io.Reader{struct{ io.Reader }{pr}, bytes.NewBufferString("bar\n")}
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			h := handles[0]
+			handles = handles[1:]
+			return h, nil
+		},
+		func() {},
+		OptLinesSetIdleTimeout(time.Millisecond*20),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Fatalf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "bar", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReaderPlainHandleClosedPending(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	// The batch period leaves a scan of the handle pending when the handle is
+	// closed for reaching its byte limit, and reads of the handle block.
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if pr == nil {
+				return nil, io.EOF
+			}
+			h := struct{ io.Reader }{pr}
+			pr = nil
+			return h, nil
+		},
+		func() {},
+		OptLinesSetBatchPeriod(time.Millisecond*20),
+		OptLinesSetMaxBytesPerHandle(4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		pw.Write([]byte("foo\n"))
+	}()
+
+	type result struct {
+		msg types.Message
+		err error
+	}
+	resChan := make(chan result)
+	go func() {
+		msg, err := r.Read()
+		resChan <- result{msg: msg, err: err}
+	}()
+	var res result
+	select {
+	case res = <-resChan:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for read")
+	}
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if exp, act := "foo", string(res.msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+	if err = r.Connect(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestReaderLineEnding(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		r, err := NewLines(
+			func() (io.Reader, error) {
+				return bytes.NewBufferString("foo\r\nbar\nbaz\r"), nil
+			},
+			func() {},
+			OptLinesTrimCR(),
+			OptLinesKeepDelimiter(keep),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		var act []string
+		for len(act) < 3 {
+			msg, err := r.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			act = append(act, string(msg.Get(0).Get())+":"+msg.Get(0).Metadata().Get("line_ending"))
+		}
+		exp := []string{"foo:crlf", "bar:lf", "baz:none"}
+		if keep {
+			exp = []string{"foo\n:crlf", "bar\n:lf", "baz:none"}
+		}
+		if !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result: %q != %q", act, exp)
+		}
+	}
+}
+
+func TestReaderCollapseDelimiters(t *testing.T) {
+	input := "foo\n\n\nbar\nbaz\n\n"
+
+	tests := map[string]struct {
+		options []func(*Lines)
+		exp     [][]string
+	}{
+		"single part": {
+			options: []func(*Lines){OptLinesCollapseDelimiters(true)},
+			exp:     [][]string{{"foo"}, {"bar"}, {"baz"}},
+		},
+		"multipart": {
+			options: []func(*Lines){
+				OptLinesSetMultipart(true),
+			},
+			exp: [][]string{{"foo"}, {"bar", "baz"}},
+		},
+		"multipart collapsed": {
+			options: []func(*Lines){
+				OptLinesSetMultipart(true),
+				OptLinesCollapseDelimiters(true),
+			},
+			exp: [][]string{{"foo", "bar", "baz"}},
+		},
+		"multipart collapsed with terminator": {
+			options: []func(*Lines){
+				OptLinesSetMultipart(true),
+				OptLinesSetMultipartTerminator([]byte("bar")),
+				OptLinesCollapseDelimiters(true),
+			},
+			exp: [][]string{{"foo"}, {"baz"}},
+		},
+		"multipart with terminator": {
+			options: []func(*Lines){
+				OptLinesSetMultipart(true),
+				OptLinesSetMultipartTerminator([]byte("bar")),
+			},
+			exp: [][]string{{"foo", "", ""}, {"baz", ""}},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			act := readAllLines(t, bytes.NewBufferString(input), test.options...)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Errorf("Wrong result: %q != %q", act, test.exp)
+			}
+		})
+	}
+}
+
+func TestReaderCSV(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		options []func(*Lines)
+		exp     [][]string
+	}{
+		"headers": {
+			content: "foo,bar\nbaz,\"qu,x\"\n",
+			options: []func(*Lines){OptLinesSetCSV([]string{"a", "b"}, 0)},
+			exp:     [][]string{{`{"a":"foo","b":"bar"}`}, {`{"a":"baz","b":"qu,x"}`}},
+		},
+		"positional": {
+			content: "foo;bar\nbaz\n",
+			options: []func(*Lines){OptLinesSetCSV(nil, ';')},
+			exp:     [][]string{{`{"col0":"foo","col1":"bar"}`}, {`{"col0":"baz"}`}},
+		},
+		"extra fields": {
+			content: "foo,bar,baz\n",
+			options: []func(*Lines){OptLinesSetCSV([]string{"a"}, ',')},
+			exp:     [][]string{{`{"a":"foo","col1":"bar","col2":"baz"}`}},
+		},
+		"header row": {
+			content: "a,b\n\nfoo,bar\n",
+			options: []func(*Lines){
+				OptLinesSetCSV(nil, ','),
+				OptLinesSetCSVHeaderRow(true),
+			},
+			exp: [][]string{{`{"a":"foo","b":"bar"}`}},
+		},
+		"delimiter kept": {
+			content: "foo,bar\n",
+			options: []func(*Lines){
+				OptLinesSetCSV([]string{"a", "b"}, ','),
+				OptLinesKeepDelimiter(true),
+			},
+			exp: [][]string{{"{\"a\":\"foo\",\"b\":\"bar\"}\n"}},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			act := readAllLines(t, bytes.NewBufferString(test.content), test.options...)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Errorf("Wrong result: %q != %q", act, test.exp)
+			}
+		})
+	}
+
+	// Header rows are read from each handle.
+	act := readAllLinesHandles(t, []io.Reader{
+		bytes.NewBufferString("a\nfoo\n"),
+		bytes.NewBufferString("b\nbar\n"),
+	}, OptLinesSetCSV(nil, ','), OptLinesSetCSVHeaderRow(true))
+	exp := [][]string{{`{"a":"foo"}`}, {`{"b":"bar"}`}}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	if _, err := NewLines(
+		func() (io.Reader, error) { return nil, io.EOF },
+		func() {},
+		OptLinesSetCSV(nil, '"'),
+	); err == nil {
+		t.Error("Expected error from invalid csv delimiter")
+	}
+}
+
+func TestReaderCSVParseError(t *testing.T) {
+	content := "foo,bar\nfoo,\"bar\nbaz,qux\n"
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString(content), nil
+		},
+		func() {},
+		OptLinesSetCSV([]string{"a", "b"}, ','),
+		OptLinesSetDecodeErrorStrategy("passthrough"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{`{"a":"foo","b":"bar"}`, `foo,"bar`, `{"a":"baz","b":"qux"}`} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act := string(msg.Get(0).Get()); act != exp {
+			t.Errorf("Wrong result: %q != %q", act, exp)
+		}
+		if hasErr := len(msg.Get(0).Metadata().Get("decode_error")) > 0; hasErr != (exp == `foo,"bar`) {
+			t.Errorf("Unexpected decode_error metadata presence: %v", hasErr)
+		}
+	}
+
+	r, err = NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString(content), nil
+		},
+		func() {},
+		OptLinesSetCSV([]string{"a", "b"}, ','),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err == nil {
+		t.Error("Expected parse error")
+	}
+}
+
+type memOffsetStore struct {
+	offset int64
+}
+
+func (m *memOffsetStore) Load() int64 {
+	return m.offset
+}
+
+func (m *memOffsetStore) Store(offset int64) error {
+	m.offset = offset
+	return nil
+}
+
+func TestReaderOffsetStore(t *testing.T) {
+	content := "foo\nbar\nbaz\n"
+	store := &memOffsetStore{}
+
+	newReader := func() *Lines {
+		r, err := NewLines(
+			func() (io.Reader, error) {
+				return strings.NewReader(content), nil
+			},
+			func() {},
+			OptLinesSetOffsetStore(store),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := newReader()
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := string(msg.Get(0).Get()); act != "foo" {
+		t.Errorf("Wrong result: %v != foo", act)
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Fatal(err)
+	}
+	if store.offset != 4 {
+		t.Errorf("Wrong stored offset: %v != 4", store.offset)
+	}
+
+	// Unacknowledged messages do not advance the stored offset.
+	if _, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Acknowledge(errors.New("nope")); err != nil {
+		t.Fatal(err)
+	}
+	if store.offset != 4 {
+		t.Errorf("Wrong stored offset: %v != 4", store.offset)
+	}
+
+	r = newReader()
+	for _, exp := range []string{"bar", "baz"} {
+		if msg, err = r.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if act := string(msg.Get(0).Get()); act != exp {
+			t.Errorf("Wrong result: %v != %v", act, exp)
+		}
+		if act := msg.Get(0).Metadata().Get("end_offset"); act != strconv.FormatInt(store.offset+4, 10) {
+			t.Errorf("Wrong end_offset: %v", act)
+		}
+		if err = r.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.offset != 12 {
+		t.Errorf("Wrong stored offset: %v != 12", store.offset)
+	}
+
+	r, err = NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString(content), nil
+		},
+		func() {},
+		OptLinesSetOffsetStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err == nil {
+		t.Error("Expected error from unseekable handle")
+	}
+}
+
+func TestReaderOffsetStoreBatchPeriod(t *testing.T) {
+	pr, pw := io.Pipe()
+	store := &memOffsetStore{}
+
+	// Lines arrive slower than the batch period, and so reads regularly
+	// return while a scan of the handle is still pending.
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if pr == nil {
+				return nil, io.EOF
+			}
+			h := pr
+			pr = nil
+			return h, nil
+		},
+		func() {},
+		OptLinesSetBatchCount(10),
+		OptLinesSetBatchPeriod(time.Millisecond*5),
+		OptLinesSetOffsetStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for i := 0; i < 20; i++ {
+			pw.Write([]byte(fmt.Sprintf("line%v\n", i)))
+			<-time.After(time.Millisecond * 2)
+		}
+		pw.Close()
+	}()
+
+	var end string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		end = msg.Get(-1).Metadata().Get("end_offset")
+		if err = r.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+		if act := strconv.FormatInt(store.offset, 10); act != end {
+			t.Errorf("Wrong stored offset: %v != %v", act, end)
+		}
+	}
+	if end != "130" {
+		t.Errorf("Wrong final offset: %v != 130", end)
+	}
+}
+
+func TestReaderFixedWidth(t *testing.T) {
+	content := "foo bar baz\nqux"
+
+	tests := map[string]struct {
+		options []func(*Lines)
+		exp     [][]string
+	}{
+		"drop partial": {
+			options: []func(*Lines){OptLinesSetFixedWidth(4, false)},
+			exp:     [][]string{{"foo "}, {"bar "}, {"baz\n"}},
+		},
+		"emit partial": {
+			options: []func(*Lines){OptLinesSetFixedWidth(4, true)},
+			exp:     [][]string{{"foo "}, {"bar "}, {"baz\n"}, {"qux"}},
+		},
+		"exact": {
+			options: []func(*Lines){OptLinesSetFixedWidth(5, false)},
+			exp:     [][]string{{"foo b"}, {"ar ba"}, {"z\nqux"}},
+		},
+		"batched": {
+			options: []func(*Lines){
+				OptLinesSetFixedWidth(8, true),
+				OptLinesSetBatchCount(2),
+			},
+			exp: [][]string{{"foo bar ", "baz\nqux"}},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			act := readAllLines(t, bytes.NewBufferString(content), test.options...)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Errorf("Wrong result: %q != %q", act, test.exp)
+			}
+		})
+	}
+
+	if _, err := NewLines(
+		func() (io.Reader, error) { return nil, io.EOF },
+		func() {},
+		OptLinesSetFixedWidth(-1, false),
+	); err == nil {
+		t.Error("Expected error from negative record length")
+	}
+}
+
+func TestReaderKeyExtractor(t *testing.T) {
+	tests := map[string]struct {
+		content   string
+		extractor func([]byte) (string, error)
+		exp       [][2]string
+	}{
+		"range": {
+			content:   "foo1 hello\nbar2 world\nba\n",
+			extractor: KeyFromRange(0, 4),
+			exp: [][2]string{
+				{"foo1 hello", "foo1"},
+				{"bar2 world", "bar2"},
+				{"ba", ""},
+			},
+		},
+		"json path": {
+			content:   `{"tenant":{"id":"foo"}}` + "\n" + `{"tenant":{"id":5}}` + "\n" + `{"tenant":{}}` + "\nnope\n",
+			extractor: KeyFromJSONPath("tenant.id"),
+			exp: [][2]string{
+				{`{"tenant":{"id":"foo"}}`, "foo"},
+				{`{"tenant":{"id":5}}`, "5"},
+				{`{"tenant":{}}`, ""},
+				{"nope", ""},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			r, err := NewLines(
+				func() (io.Reader, error) {
+					return bytes.NewBufferString(test.content), nil
+				},
+				func() {},
+				OptLinesSetKeyExtractor(test.extractor),
+				OptLinesSetDecodeErrorStrategy("passthrough"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = r.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			for _, exp := range test.exp {
+				msg, err := r.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				part := msg.Get(0)
+				act := [2]string{string(part.Get()), part.Metadata().Get("key")}
+				if act != exp {
+					t.Errorf("Wrong result: %q != %q", act, exp)
+				}
+				if hasErr := len(part.Metadata().Get("decode_error")) > 0; hasErr != (exp[1] == "") {
+					t.Errorf("Unexpected decode_error metadata presence: %v", hasErr)
+				}
+			}
+		})
+	}
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString("foo\nb\n"), nil
+		},
+		func() {},
+		OptLinesSetKeyExtractor(KeyFromRange(1, 3)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err == nil {
+		t.Error("Expected key extraction error")
+	}
+}
+
+func TestReaderHeartbeat(t *testing.T) {
+	pr, pw := io.Pipe()
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return pr, nil
+		},
+		func() {},
+		OptLinesSetMultipart(true),
+		OptLinesSetHeartbeat(time.Millisecond*50),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		pw.Close()
+		r.CloseAsync()
+		if err := r.WaitForClose(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		pw.Write([]byte("foo\nbar\n"))
+	}()
+
+	var act []string
+	for len(act) < 4 {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parts []string
+		for _, p := range message.GetAllBytes(msg) {
+			parts = append(parts, string(p))
+		}
+		if msg.Get(0).Metadata().Get("heartbeat") == "true" {
+			parts = []string{"heartbeat"}
+			if len(act) == 2 {
+				// The partial message is preserved across heartbeats.
+				go pw.Write([]byte("\n"))
+			}
+		}
+		act = append(act, strings.Join(parts, ","))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp := []string{"heartbeat", "heartbeat", "heartbeat", "foo,bar"}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestReaderBytesRead(t *testing.T) {
+	handles := []io.Reader{
+		bytes.NewBufferString("foo\nbar\n"),
+		bytes.NewBufferString("hello world"),
+	}
+
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			if len(handles) == 0 {
+				return nil, io.EOF
+			}
+			h := handles[0]
+			handles = handles[1:]
+			return h, nil
+		},
+		func() {},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	type progress struct {
+		line          string
+		handle, total int64
+	}
+	var act []progress
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, progress{string(msg.Get(0).Get()), r.BytesRead(), r.TotalBytesRead()})
+		if err = r.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp := []progress{
+		{"foo", 4, 4},
+		{"bar", 8, 8},
+		{"hello world", 11, 19},
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+}
+
+func TestReaderWholeStream(t *testing.T) {
+	tests := map[string]struct {
+		handles []string
+		options []func(*Lines)
+		exp     [][]string
+	}{
+		"single handle": {
+			handles: []string{"foo\nbar\n\nbaz"},
+			exp:     [][]string{{"foo\nbar\n\nbaz"}},
+		},
+		"multiple handles": {
+			handles: []string{"foo\n", "", "bar\nbaz\n"},
+			exp:     [][]string{{"foo\n"}, {"bar\nbaz\n"}},
+		},
+		"ignores delimiter": {
+			handles: []string{"foo,bar"},
+			options: []func(*Lines){OptLinesSetDelimiter(",")},
+			exp:     [][]string{{"foo,bar"}},
+		},
+		"truncated": {
+			handles: []string{"foobarbaz"},
+			options: []func(*Lines){
+				OptLinesSetMaxBuffer(6),
+				OptLinesSetOversizeStrategy("truncate"),
+			},
+			exp: [][]string{{"foobar"}},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var handles []io.Reader
+			for _, h := range test.handles {
+				handles = append(handles, bytes.NewBufferString(h))
+			}
+			options := append([]func(*Lines){OptLinesSetWholeStream(true)}, test.options...)
+			act := readAllLinesHandles(t, handles, options...)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Errorf("Wrong result: %q != %q", act, test.exp)
+			}
+		})
+	}
+}
+
+func TestReaderDelimiterMetadata(t *testing.T) {
+	r, err := NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString("foo\r\nbar\nbaz|qux;;quz"), nil
+		},
+		func() {},
+		OptLinesSetDelimiters([][]byte{[]byte("\n"), []byte("\r\n"), []byte("|"), []byte(";;")}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range [][2]string{
+		{"foo", "crlf"},
+		{"bar", "lf"},
+		{"baz", "7c"},
+		{"qux", "3b3b"},
+		{"quz", ""},
+	} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		act := [2]string{string(msg.Get(0).Get()), msg.Get(0).Metadata().Get("delimiter")}
+		if act != exp {
+			t.Errorf("Wrong result: %q != %q", act, exp)
+		}
+	}
+
+	// A single delimiter is not recorded.
+	if r, err = NewLines(
+		func() (io.Reader, error) {
+			return bytes.NewBufferString("foo\n"), nil
+		},
+		func() {},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := msg.Get(0).Metadata().Get("delimiter"); len(act) > 0 {
+		t.Errorf("Unexpected delimiter metadata: %v", act)
+	}
+}
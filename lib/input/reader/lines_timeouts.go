@@ -0,0 +1,106 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"context"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+// lineTimeouts tracks the periods of time that a Lines reader waits for lines,
+// which are the read timeout of each call to Read, and the idle timeout and
+// heartbeat period of a handle that goes without producing a line.
+type lineTimeouts struct {
+	read      time.Duration
+	idle      time.Duration
+	heartbeat time.Duration
+
+	// Whether the current handle was closed for being idle, and when the most
+	// recent line and heartbeat were produced.
+	idleClosed bool
+	lastLine   time.Time
+	lastBeat   time.Time
+
+	// Set when the most recent message read is a heartbeat, which is not
+	// tracked for acknowledgement.
+	beating bool
+}
+
+// readContext returns a context that ends once the read timeout has elapsed.
+func (t *lineTimeouts) readContext(ctx context.Context) (context.Context, func()) {
+	if t.read <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.read)
+}
+
+// scanContext returns a context that ends once either the idle timeout of an
+// open handle has elapsed or a heartbeat is due.
+func (t *lineTimeouts) scanContext(ctx context.Context, open bool) (context.Context, func()) {
+	var deadline time.Time
+	if t.idle > 0 && !t.idleClosed && open {
+		deadline = t.lastLine.Add(t.idle)
+	}
+	if t.heartbeat > 0 {
+		if beat := t.nextHeartbeat(); deadline.IsZero() || beat.Before(deadline) {
+			deadline = beat
+		}
+	}
+	if deadline.IsZero() {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// idleElapsed returns whether the current handle has gone without producing a
+// line for longer than the idle timeout.
+func (t *lineTimeouts) idleElapsed() bool {
+	return t.idle > 0 && !t.idleClosed && time.Since(t.lastLine) >= t.idle
+}
+
+// beatDue returns whether a heartbeat is due.
+func (t *lineTimeouts) beatDue() bool {
+	return t.heartbeat > 0 && !time.Now().Before(t.nextHeartbeat())
+}
+
+// nextHeartbeat returns the time at which a heartbeat is due, which is a
+// heartbeat period after the most recent line or heartbeat.
+func (t *lineTimeouts) nextHeartbeat() time.Time {
+	last := t.lastLine
+	if t.lastBeat.After(last) {
+		last = t.lastBeat
+	}
+	return last.Add(t.heartbeat)
+}
+
+// line records that a line was produced.
+func (t *lineTimeouts) line() {
+	t.lastLine = time.Now()
+}
+
+// beat records that a heartbeat was produced.
+func (t *lineTimeouts) beat() {
+	t.lastBeat, t.beating = time.Now(), true
+}
+
+//------------------------------------------------------------------------------
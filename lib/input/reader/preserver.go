@@ -37,6 +37,10 @@ type Preserver struct {
 	unAckMessages  []types.Message
 	resendMessages []types.Message
 
+	maxRetries  int
+	retries     map[types.Message]int
+	onExhausted func(msg types.Message, err error)
+
 	throt *throttle.Type
 
 	r Type
@@ -52,6 +56,22 @@ func NewPreserver(r Type) *Preserver {
 	}
 }
 
+// NewPreserverWithRetries returns a new Preserver wrapper around a reader.Type
+// that resends each message at most maxRetries times. Once a message has failed
+// more than maxRetries times it is given to onExhausted along with the latest
+// error and is treated as having been successfully propagated.
+func NewPreserverWithRetries(
+	r Type,
+	maxRetries int,
+	onExhausted func(msg types.Message, err error),
+) *Preserver {
+	p := NewPreserver(r)
+	p.maxRetries = maxRetries
+	p.retries = map[types.Message]int{}
+	p.onExhausted = onExhausted
+	return p
+}
+
 //------------------------------------------------------------------------------
 
 // Connect attempts to establish a connection to the source, if unsuccessful
@@ -68,6 +88,11 @@ func (p *Preserver) Connect() error {
 func (p *Preserver) Acknowledge(err error) error {
 	if err == nil {
 		p.throt.Reset()
+		if p.retries != nil {
+			for _, msg := range p.unAckMessages {
+				delete(p.retries, msg)
+			}
+		}
 		p.unAckMessages = nil
 		if len(p.resendMessages) == 0 {
 			// Only propagate ack if we are done resending buffered messages.
@@ -76,10 +101,34 @@ func (p *Preserver) Acknowledge(err error) error {
 		return nil
 	}
 
-	// Do not propagate errors since we are handling them here by resending.
-	p.resendMessages = append(p.resendMessages, p.unAckMessages...)
+	if p.retries == nil {
+		// Do not propagate errors since we are handling them here by resending.
+		p.resendMessages = append(p.resendMessages, p.unAckMessages...)
+		p.unAckMessages = nil
+		p.throt.Retry()
+		return nil
+	}
+
+	var exhausted bool
+	for _, msg := range p.unAckMessages {
+		if p.retries[msg]++; p.retries[msg] > p.maxRetries {
+			delete(p.retries, msg)
+			p.onExhausted(msg, err)
+			exhausted = true
+			continue
+		}
+		p.resendMessages = append(p.resendMessages, msg)
+	}
 	p.unAckMessages = nil
-	p.throt.Retry()
+	if len(p.resendMessages) > 0 {
+		p.throt.Retry()
+		return nil
+	}
+	if exhausted {
+		// All failed messages have been exhausted and so we can move on.
+		p.throt.Reset()
+		return p.r.Acknowledge(nil)
+	}
 	return nil
 }
 
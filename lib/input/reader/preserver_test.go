@@ -367,3 +367,66 @@ func TestPreserverBufferBatchedAcks(t *testing.T) {
 }
 
 //------------------------------------------------------------------------------
+
+func TestPreserverWithRetries(t *testing.T) {
+	t.Parallel()
+
+	readerImpl := newMockReader()
+
+	var exhausted []types.Message
+	pres := NewPreserverWithRetries(readerImpl, 2, func(msg types.Message, err error) {
+		exhausted = append(exhausted, msg)
+	})
+
+	sendMsg := message.New([][]byte{[]byte("poison")})
+	readerImpl.msgToSnd = sendMsg
+	expErr := errors.New("failed")
+
+	go func() {
+		select {
+		case readerImpl.readChan <- nil:
+		case <-time.After(time.Second):
+			t.Error("Timed out")
+		}
+		select {
+		case readerImpl.ackChan <- nil:
+		case <-time.After(time.Second):
+			t.Error("Timed out")
+		}
+	}()
+
+	msg, err := pres.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != sendMsg {
+		t.Error("Wrong message returned")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = pres.Acknowledge(expErr); err != nil {
+			t.Fatal(err)
+		}
+		if msg, err = pres.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if msg != sendMsg {
+			t.Error("Wrong message returned")
+		}
+		if len(exhausted) > 0 {
+			t.Fatalf("Message exhausted early on retry %v", i)
+		}
+	}
+
+	if err = pres.Acknowledge(expErr); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := []types.Message{sendMsg}, exhausted; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong exhausted messages: %v != %v", act, exp)
+	}
+	if readerImpl.ackRcvd != nil {
+		t.Errorf("Wrong ack propagated: %v", readerImpl.ackRcvd)
+	}
+}
+
+//------------------------------------------------------------------------------
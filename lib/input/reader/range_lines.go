@@ -0,0 +1,179 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// RangeLines is a reader implementation that reads line delimited messages
+// from a byte range of an io.ReaderAt. Only lines that begin within the range
+// are read, and the last of them is read in full even when it ends beyond the
+// range. This allows a single large file to be divided between readers by
+// adjacent ranges without any lines being read twice or missed. The
+// `start_offset` and `end_offset` metadata fields of each line are offsets
+// within the io.ReaderAt rather than the range.
+type RangeLines struct {
+	ra         io.ReaderAt
+	start, end int64
+	base       int64
+	opened     bool
+
+	lines *Lines
+}
+
+// NewRangeLines creates a new RangeLines reader that reads lines beginning
+// within the range [start, end) of an io.ReaderAt. An end offset of zero or
+// less means lines are read until the end of the io.ReaderAt. Options for the
+// underlying Lines reader can be provided, but the delimiter must be a single
+// fixed sequence, and neither multipart messages nor whole stream mode are
+// supported.
+func NewRangeLines(
+	ra io.ReaderAt,
+	start, end int64,
+	options ...func(r *Lines),
+) (*RangeLines, error) {
+	if start < 0 {
+		return nil, errors.New("start offset must not be negative")
+	}
+	if end <= 0 {
+		end = math.MaxInt64
+	}
+	r := RangeLines{
+		ra:    ra,
+		start: start,
+		end:   end,
+	}
+
+	var err error
+	if r.lines, err = NewLines(r.open, func() {}, options...); err != nil {
+		return nil, err
+	}
+	if r.lines.customSplit != nil || r.lines.delimRegexp != nil ||
+		len(r.lines.delimiters) > 0 || r.lines.delimFunc != nil {
+		return nil, errors.New("range reads require a fixed delimiter")
+	}
+	if r.lines.wholeStream {
+		return nil, errors.New("range reads do not support whole stream mode")
+	}
+	if r.lines.multipart || r.lines.batch.count > 1 || r.lines.batch.period > 0 {
+		return nil, errors.New("range reads do not support multipart messages")
+	}
+	return &r, nil
+}
+
+//------------------------------------------------------------------------------
+
+// open returns a section of the io.ReaderAt that begins with the first line
+// beginning within the range and ends before the first line beginning after
+// it, so that lines outside of the range are never scanned.
+func (r *RangeLines) open() (io.Reader, error) {
+	if r.opened {
+		return nil, io.EOF
+	}
+	r.opened = true
+
+	start, err := r.lineStart(r.start)
+	if err != nil {
+		return nil, err
+	}
+	end := int64(math.MaxInt64)
+	if r.end < math.MaxInt64 {
+		if end, err = r.lineStart(r.end); err == io.EOF {
+			end = math.MaxInt64
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	r.base = start
+	return io.NewSectionReader(r.ra, start, end-start), nil
+}
+
+// lineStart returns the offset of the first line that begins at or after an
+// offset of the io.ReaderAt, or io.EOF if there isn't one.
+func (r *RangeLines) lineStart(offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+
+	// We search from the delimiter preceding the offset (if any), which tells
+	// us whether a line begins exactly at the offset.
+	delim := r.lines.delimiter
+	if offset -= int64(len(delim)); offset < 0 {
+		offset = 0
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.ra.ReadAt(buf, offset)
+		if i := bytes.Index(buf[:n], delim); i >= 0 {
+			return offset + int64(i+len(delim)), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		// Keep enough of our buffer to catch a delimiter that straddles it.
+		offset += int64(n - len(delim) + 1)
+	}
+}
+
+// Connect seeks to the start of the byte range.
+func (r *RangeLines) Connect() error {
+	return r.lines.Connect()
+}
+
+// Read attempts to read a new line from the byte range.
+func (r *RangeLines) Read() (types.Message, error) {
+	msg, err := r.lines.Read()
+	if err != nil {
+		return nil, err
+	}
+	part := msg.Get(0)
+	part.Metadata().Set("start_offset", strconv.FormatInt(r.base+r.lines.token.offset, 10))
+	part.Metadata().Set("end_offset", strconv.FormatInt(r.base+r.lines.token.end, 10))
+	return msg, nil
+}
+
+// Acknowledge confirms whether or not our unacknowledged messages have been
+// successfully propagated or not.
+func (r *RangeLines) Acknowledge(err error) error {
+	return r.lines.Acknowledge(err)
+}
+
+// CloseAsync shuts down the reader.
+func (r *RangeLines) CloseAsync() {
+	r.lines.CloseAsync()
+}
+
+// WaitForClose blocks until the reader has closed down.
+func (r *RangeLines) WaitForClose(timeout time.Duration) error {
+	return r.lines.WaitForClose(timeout)
+}
+
+//------------------------------------------------------------------------------
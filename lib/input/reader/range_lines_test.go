@@ -0,0 +1,186 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func TestRangeLines(t *testing.T) {
+	type testRange struct {
+		start, end int64
+	}
+	tests := []struct {
+		name   string
+		input  string
+		opts   []func(*Lines)
+		ranges []testRange
+		exp    [][]string
+	}{
+		{
+			name:   "mid line boundaries",
+			input:  "foo\nbar\nbaz\nqux\n",
+			ranges: []testRange{{0, 5}, {5, 10}, {10, 0}},
+			exp:    [][]string{{"foo", "bar"}, {"baz"}, {"qux"}},
+		},
+		{
+			name:   "line start boundaries",
+			input:  "foo\nbar\nbaz\nqux",
+			ranges: []testRange{{0, 4}, {4, 8}, {8, 0}},
+			exp:    [][]string{{"foo"}, {"bar"}, {"baz", "qux"}},
+		},
+		{
+			name:   "multi byte delimiter",
+			input:  "foo||bar||baz",
+			opts:   []func(*Lines){OptLinesSetDelimiter("||")},
+			ranges: []testRange{{0, 6}, {6, 10}, {10, 0}},
+			exp:    [][]string{{"foo", "bar"}, nil, {"baz"}},
+		},
+		{
+			name:   "long line",
+			input:  strings.Repeat("a", 40000) + "\nbar\n",
+			ranges: []testRange{{0, 10}, {10, 0}},
+			exp:    [][]string{{strings.Repeat("a", 40000)}, {"bar"}},
+		},
+	}
+
+	for _, test := range tests {
+		for i, rng := range test.ranges {
+			r, err := NewRangeLines(bytes.NewReader([]byte(test.input)), rng.start, rng.end, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var act []string
+			for {
+				if err = r.Connect(); err == types.ErrTypeClosed {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				msg, err := r.Read()
+				if err == types.ErrTypeClosed {
+					break
+				}
+				if err == types.ErrNotConnected {
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				act = append(act, string(msg.Get(0).Get()))
+				if err = r.Acknowledge(nil); err != nil {
+					t.Error(err)
+				}
+			}
+			if exp := test.exp[i]; !reflect.DeepEqual(act, exp) {
+				t.Errorf("Wrong result for '%v' range %v: %q != %q", test.name, i, act, exp)
+			}
+		}
+	}
+}
+
+func TestRangeLinesBadOptions(t *testing.T) {
+	if _, err := NewRangeLines(bytes.NewReader(nil), 0, 0, OptLinesSetMultipart(true)); err == nil {
+		t.Error("Expected error from multipart option")
+	}
+	if _, err := NewRangeLines(bytes.NewReader(nil), 0, 0, OptLinesSetDelimiters([][]byte{[]byte("\n"), []byte("\r\n")})); err == nil {
+		t.Error("Expected error from delimiters option")
+	}
+	if _, err := NewRangeLines(bytes.NewReader(nil), 0, 0, OptLinesSetHandleDelimiter(func() string { return "\t" })); err == nil {
+		t.Error("Expected error from handle delimiter option")
+	}
+	if _, err := NewRangeLines(bytes.NewReader(nil), 0, 0, OptLinesSetWholeStream(true)); err == nil {
+		t.Error("Expected error from whole stream option")
+	}
+	if _, err := NewRangeLines(bytes.NewReader(nil), -1, 0); err == nil {
+		t.Error("Expected error from negative start")
+	}
+}
+
+func TestRangeLinesOffsets(t *testing.T) {
+	r, err := NewRangeLines(bytes.NewReader([]byte("foo\nbar\nbaz\n")), 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "baz", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong line: %v != %v", act, exp)
+	}
+	if exp, act := "8", msg.Get(0).Metadata().Get("start_offset"); exp != act {
+		t.Errorf("Wrong start offset: %v != %v", act, exp)
+	}
+	if exp, act := "12", msg.Get(0).Metadata().Get("end_offset"); exp != act {
+		t.Errorf("Wrong end offset: %v != %v", act, exp)
+	}
+}
+
+func TestRangeLinesPartError(t *testing.T) {
+	// The range starts part way through the first line, which is never read.
+	r, err := NewRangeLines(bytes.NewReader([]byte("foo\nbar\nbaz\n")), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		line, start string
+		ackErr      error
+	}{
+		{"bar", "4", testPartError{0}},
+		{"bar", "4", nil},
+		{"baz", "8", nil},
+	} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp, act := test.line, string(msg.Get(0).Get()); exp != act {
+			t.Errorf("Wrong line: %v != %v", act, exp)
+		}
+		if exp, act := test.start, msg.Get(0).Metadata().Get("start_offset"); exp != act {
+			t.Errorf("Wrong start offset: %v != %v", act, exp)
+		}
+		if err = r.Acknowledge(test.ackErr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Errorf("Wrong error: %v != %v", err, types.ErrNotConnected)
+	}
+}
+
+//------------------------------------------------------------------------------
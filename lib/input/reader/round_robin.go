@@ -0,0 +1,235 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// roundRobinRead records the child that a message was read from and the number
+// of parts within the message.
+type roundRobinRead struct {
+	child int
+	parts int
+}
+
+// roundRobinPartError is a PartError containing the failed parts of a single
+// child, counted from the first part read from that child since the last
+// acknowledgement.
+type roundRobinPartError struct {
+	error
+	failed []int
+}
+
+func (e roundRobinPartError) FailedParts() []int {
+	return e.failed
+}
+
+// RoundRobin is a reader that interleaves the messages of multiple child
+// readers by reading from each in turn. Children that are not connected are
+// skipped until the next call to Connect, and the reader is only closed once
+// all children are closed. Acknowledgements are routed to the children that
+// the acknowledged messages were read from. RoundRobin implements reader.Type.
+type RoundRobin struct {
+	children     []Type
+	closed       []bool
+	disconnected []bool
+	next         int
+
+	// The messages read since the last acknowledgement, in order.
+	unacked []roundRobinRead
+}
+
+// NewRoundRobin returns a new RoundRobin reader of a number of child readers.
+func NewRoundRobin(children ...Type) *RoundRobin {
+	r := &RoundRobin{
+		children:     children,
+		closed:       make([]bool, len(children)),
+		disconnected: make([]bool, len(children)),
+	}
+	for i := range r.disconnected {
+		r.disconnected[i] = true
+	}
+	return r
+}
+
+//------------------------------------------------------------------------------
+
+// allClosed returns whether all children are closed.
+func (r *RoundRobin) allClosed() bool {
+	for _, closed := range r.closed {
+		if !closed {
+			return false
+		}
+	}
+	return true
+}
+
+// Connect attempts to connect each child that is not currently connected. A
+// child that returns types.ErrTypeClosed is considered closed. Returns nil if
+// at least one child is connected, otherwise the first error encountered.
+func (r *RoundRobin) Connect() error {
+	var firstErr error
+	for i, child := range r.children {
+		if r.closed[i] || !r.disconnected[i] {
+			continue
+		}
+		err := child.Connect()
+		if err == types.ErrTypeClosed {
+			r.closed[i] = true
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		r.disconnected[i] = false
+	}
+	if r.allClosed() {
+		return types.ErrTypeClosed
+	}
+	for i := range r.children {
+		if !r.closed[i] && !r.disconnected[i] {
+			return nil
+		}
+	}
+	return firstErr
+}
+
+// Read attempts to read a message from the next child in turn, moving onto the
+// following child when one is not connected, closed or times out. Returns
+// types.ErrTypeClosed once all children are closed, types.ErrTimeout if any
+// child timed out and otherwise types.ErrNotConnected when no children are
+// connected.
+func (r *RoundRobin) Read() (types.Message, error) {
+	timedOut := false
+	for n := 0; n < len(r.children); n++ {
+		i := (r.next + n) % len(r.children)
+		if r.closed[i] || r.disconnected[i] {
+			continue
+		}
+		msg, err := r.children[i].Read()
+		switch err {
+		case nil:
+			r.next = (i + 1) % len(r.children)
+			r.unacked = append(r.unacked, roundRobinRead{
+				child: i,
+				parts: msg.Len(),
+			})
+			return msg, nil
+		case types.ErrNotConnected:
+			r.disconnected[i] = true
+		case types.ErrTypeClosed:
+			r.closed[i] = true
+		case types.ErrTimeout:
+			timedOut = true
+		default:
+			r.next = (i + 1) % len(r.children)
+			return nil, err
+		}
+	}
+	if r.allClosed() {
+		return nil, types.ErrTypeClosed
+	}
+	if timedOut {
+		return nil, types.ErrTimeout
+	}
+	return nil, types.ErrNotConnected
+}
+
+// Acknowledge routes an acknowledgement of the messages read since the last
+// call to each child that they were read from. When the error is a PartError
+// each child only receives the failed parts that it produced, and children
+// without failed parts receive a successful acknowledgement.
+func (r *RoundRobin) Acknowledge(err error) error {
+	unacked := r.unacked
+	r.unacked = nil
+
+	// The number of parts read from each child, and the failed parts of each
+	// child relative to its own reads.
+	childParts := map[int]int{}
+	childFailed := map[int][]int{}
+	var order []int
+
+	var failed map[int]struct{}
+	if pErr, ok := err.(PartError); ok {
+		failed = map[int]struct{}{}
+		for _, i := range pErr.FailedParts() {
+			failed[i] = struct{}{}
+		}
+	}
+
+	index := 0
+	for _, read := range unacked {
+		if _, exists := childParts[read.child]; !exists {
+			order = append(order, read.child)
+		}
+		for j := 0; j < read.parts; j++ {
+			if _, isFailed := failed[index]; isFailed {
+				childFailed[read.child] = append(childFailed[read.child], childParts[read.child]+j)
+			}
+			index++
+		}
+		childParts[read.child] += read.parts
+	}
+
+	var firstErr error
+	for _, i := range order {
+		childErr := err
+		if failed != nil {
+			childErr = nil
+			if parts := childFailed[i]; len(parts) > 0 {
+				childErr = roundRobinPartError{error: err, failed: parts}
+			}
+		}
+		if aErr := r.children[i].Acknowledge(childErr); aErr != nil && firstErr == nil {
+			firstErr = aErr
+		}
+	}
+	return firstErr
+}
+
+// CloseAsync triggers the asynchronous closing of all children.
+func (r *RoundRobin) CloseAsync() {
+	for _, child := range r.children {
+		child.CloseAsync()
+	}
+}
+
+// WaitForClose blocks until either all children are finished closing or a
+// timeout occurs.
+func (r *RoundRobin) WaitForClose(tout time.Duration) error {
+	stopBy := time.Now().Add(tout)
+	for _, child := range r.children {
+		if err := child.WaitForClose(time.Until(stopBy)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
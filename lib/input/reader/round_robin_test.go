@@ -0,0 +1,196 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func TestRoundRobinLines(t *testing.T) {
+	newChild := func(content ...string) Type {
+		i := -1
+		r, err := NewLines(
+			func() (io.Reader, error) {
+				if i++; i >= len(content) {
+					return nil, io.EOF
+				}
+				return bytes.NewBufferString(content[i]), nil
+			},
+			func() {},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := NewRoundRobin(
+		newChild("a1\na2\na3\n"),
+		newChild("b1\n", "b2\n"),
+		newChild("c1\n"),
+	)
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"a1", "b1", "c1", "a2", "a3", "b2"}
+	var act []string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err != nil && err != types.ErrTypeClosed {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err == types.ErrTypeClosed {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestRoundRobinSkips(t *testing.T) {
+	a := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"a1"}},
+			{err: types.ErrNotConnected},
+			{content: []string{"a2"}},
+		},
+	}
+	b := &scriptedReader{
+		reads: []scriptedRead{
+			{err: types.ErrTimeout},
+			{content: []string{"b1"}},
+			{content: []string{"b2"}},
+		},
+	}
+	r := NewRoundRobin(a, b)
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []struct {
+		content string
+		err     error
+	}{
+		{"a1", nil},
+		{"", types.ErrTimeout},
+		{"b1", nil},
+		{"b2", nil},
+		{"", types.ErrNotConnected},
+		{"a2", nil},
+		{"", types.ErrTypeClosed},
+	} {
+		msg, err := r.Read()
+		if err != exp.err {
+			t.Fatalf("Wrong error: %v != %v", err, exp.err)
+		}
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if act := string(msg.Get(0).Get()); act != exp.content {
+			t.Errorf("Wrong result: %v != %v", act, exp.content)
+		}
+	}
+}
+
+func TestRoundRobinAcknowledge(t *testing.T) {
+	a := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"a1", "a2"}},
+			{content: []string{"a3"}},
+		},
+	}
+	b := &scriptedReader{
+		reads: []scriptedRead{
+			{content: []string{"b1"}},
+			{content: []string{"b2"}},
+		},
+	}
+	r := NewRoundRobin(a, b)
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads a1 a2, b1, a3
+	for i := 0; i < 3; i++ {
+		if _, err := r.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Acknowledge(testPartError{1, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.acks) != 1 || b.acks[0] != nil {
+		t.Errorf("Wrong acks of b: %v", b.acks)
+	}
+	if len(a.acks) != 1 {
+		t.Fatalf("Wrong count of acks of a: %v", a.acks)
+	}
+	pErr, ok := a.acks[0].(PartError)
+	if !ok {
+		t.Fatalf("Wrong ack type of a: %T", a.acks[0])
+	}
+	if exp, act := []int{1, 2}, pErr.FailedParts(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong failed parts: %v != %v", act, exp)
+	}
+
+	// Reads b2
+	if _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	errTest := errors.New("test err")
+	if err := r.Acknowledge(errTest); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.acks) != 1 {
+		t.Errorf("Unexpected ack of a: %v", a.acks)
+	}
+	if len(b.acks) != 2 || b.acks[1] != errTest {
+		t.Errorf("Wrong acks of b: %v", b.acks)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,100 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+// SingleFile is a reader implementation that reads the full contents of a
+// single file as one message and then closes.
+type SingleFile struct {
+	path   string
+	target *fileTarget
+	done   bool
+}
+
+// NewSingleFile creates a new SingleFile reader for a file path.
+func NewSingleFile(path string) *SingleFile {
+	return &SingleFile{
+		path: path,
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Connect checks that the file exists, and returns types.ErrTypeClosed once
+// the file has been read.
+func (s *SingleFile) Connect() error {
+	if s.done {
+		return types.ErrTypeClosed
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("path '%v' is a directory", s.path)
+	}
+	s.target = &fileTarget{path: s.path, info: info}
+	return nil
+}
+
+// Read returns the contents of the file as a message on the first call, and
+// subsequent calls return types.ErrNotConnected.
+func (s *SingleFile) Read() (types.Message, error) {
+	if s.target == nil || s.done {
+		return nil, types.ErrNotConnected
+	}
+	msgBytes, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file '%v': %v", s.path, err)
+	}
+	s.done = true
+
+	msg := message.New([][]byte{msgBytes})
+	s.target.setMetadata(msg.Get(0), "")
+	return msg, nil
+}
+
+// Acknowledge is a noop.
+func (s *SingleFile) Acknowledge(err error) error {
+	return nil
+}
+
+// CloseAsync is a noop.
+func (s *SingleFile) CloseAsync() {
+}
+
+// WaitForClose is a noop.
+func (s *SingleFile) WaitForClose(timeout time.Duration) error {
+	return nil
+}
+
+//------------------------------------------------------------------------------
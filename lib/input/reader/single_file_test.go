@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func TestSingleFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "benthos_single_file_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "foo")
+	if err = ioutil.WriteFile(path, []byte("hello world"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewSingleFile(path)
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "hello world", string(msg.Get(0).Get()); exp != act {
+		t.Errorf("Wrong result: %v != %v", act, exp)
+	}
+	meta := msg.Get(0).Metadata()
+	if exp, act := path, meta.Get("path"); exp != act {
+		t.Errorf("Wrong path metadata: %v != %v", act, exp)
+	}
+	if exp, act := "11", meta.Get("size_bytes"); exp != act {
+		t.Errorf("Wrong size_bytes metadata: %v != %v", act, exp)
+	}
+	if len(meta.Get("mod_time")) == 0 {
+		t.Error("Expected mod_time metadata")
+	}
+	if err = r.Acknowledge(nil); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = r.Read(); err != types.ErrNotConnected {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrNotConnected)
+	}
+	if err = r.Connect(); err != types.ErrTypeClosed {
+		t.Errorf("Wrong error returned: %v != %v", err, types.ErrTypeClosed)
+	}
+}
+
+func TestSingleFileBadPath(t *testing.T) {
+	if err := NewSingleFile("/does/not/exist").Connect(); err == nil {
+		t.Error("Expected error from bad path")
+	}
+}
+
+//------------------------------------------------------------------------------
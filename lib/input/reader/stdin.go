@@ -0,0 +1,65 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"io"
+	"os"
+)
+
+//------------------------------------------------------------------------------
+
+// NewStdinLines returns a Lines reader of os.Stdin. Reads from stdin cannot
+// normally be interrupted, and so stdin is instead copied into a pipe by a
+// background goroutine, allowing CloseAsync to unblock a pending read by
+// closing the pipe. The goroutine exits once stdin ends or the next time it
+// receives data after the reader is closed.
+func NewStdinLines(options ...func(r *Lines)) (*Lines, error) {
+	return newPipedLines(os.Stdin, options...)
+}
+
+// newPipedLines returns a Lines reader of a single source that is copied
+// through a pipe in order for reads to be interrupted when closing.
+func newPipedLines(src io.Reader, options ...func(r *Lines)) (*Lines, error) {
+	pr, pw := io.Pipe()
+
+	consumed := false
+	return NewLines(
+		func() (io.Reader, error) {
+			// The source is only read once.
+			if consumed {
+				return nil, io.EOF
+			}
+			consumed = true
+			go func() {
+				_, err := io.Copy(pw, src)
+				pw.CloseWithError(err)
+			}()
+			return pr, nil
+		},
+		func() {
+			pr.Close()
+		},
+		options...,
+	)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,103 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+//------------------------------------------------------------------------------
+
+func TestPipedLines(t *testing.T) {
+	r, err := newPipedLines(bytes.NewBufferString("foo\nbar\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var act []string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		act = append(act, string(msg.Get(0).Get()))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Error(err)
+		}
+	}
+	if exp := []string{"foo", "bar"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestPipedLinesCloseUnblocks(t *testing.T) {
+	// A source that never produces data nor ends, much like an idle stdin.
+	src, srcW := io.Pipe()
+	defer srcW.Close()
+
+	r, err := newPipedLines(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	readErr := make(chan error)
+	go func() {
+		_, err := r.Read()
+		readErr <- err
+	}()
+
+	<-time.After(time.Millisecond * 10)
+	r.CloseAsync()
+	select {
+	case err = <-readErr:
+		if err == nil {
+			t.Error("Expected error from closed read")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected blocked read to be unblocked")
+	}
+	if err = r.WaitForClose(time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,95 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//------------------------------------------------------------------------------
+
+// NewVarintFrames returns a Lines reader that frames the handles it reads into
+// records, each prefixed by its length encoded as an unsigned varint, such as
+// length-delimited protobuf messages. Each record is emitted without its
+// prefix, including records of zero length, which are emitted as a message
+// with an empty part unless multipart messages are enabled, in which case a
+// record of zero length ends a message. A prefix that
+// declares a record longer than maxFrame bytes results in a read error, which
+// guards against corrupt input, and a maxFrame of zero places no limit on
+// records other than the maximum buffer size of the reader.
+func NewVarintFrames(
+	handleCtor func() (io.Reader, error),
+	onClose func(),
+	maxFrame int,
+	options ...func(r *Lines),
+) (*Lines, error) {
+	if maxFrame < 0 {
+		return nil, fmt.Errorf("max frame size must not be negative: %v", maxFrame)
+	}
+	frameOpts := []func(r *Lines){
+		OptLinesSetSplitFunc(splitVarintFrames(uint64(maxFrame))),
+		func(r *Lines) { r.emitEmpty = true },
+	}
+	if maxFrame+binary.MaxVarintLen64 > bufio.MaxScanTokenSize {
+		frameOpts = append(frameOpts, OptLinesSetMaxBuffer(maxFrame+binary.MaxVarintLen64))
+	}
+	return NewLines(handleCtor, onClose, append(frameOpts, options...)...)
+}
+
+// errTruncatedFrame is returned when a handle ends part way through a record.
+var errTruncatedFrame = errors.New("handle ended within a length prefixed frame")
+
+// splitVarintFrames returns a split function that frames data into records
+// prefixed by their length as an unsigned varint.
+func splitVarintFrames(maxFrame uint64) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+		length, n := binary.Uvarint(data)
+		if n < 0 {
+			return 0, nil, errors.New("frame length prefix overflows 64 bits")
+		}
+		if n == 0 {
+			if atEOF {
+				return 0, nil, errTruncatedFrame
+			}
+			return 0, nil, nil
+		}
+		if maxFrame > 0 && length > maxFrame {
+			return 0, nil, fmt.Errorf("frame length %v exceeds the maximum of %v", length, maxFrame)
+		}
+		if uint64(len(data)-n) < length {
+			if atEOF {
+				return 0, nil, errTruncatedFrame
+			}
+			return 0, nil, nil
+		}
+		end := n + int(length)
+		return end, data[n:end], nil
+	}
+}
+
+//------------------------------------------------------------------------------
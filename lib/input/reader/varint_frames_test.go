@@ -0,0 +1,165 @@
+// Copyright (c) 2019 Ashley Jeffs
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/Jeffail/benthos/v3/lib/message"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+func varintFrames(frames ...string) []byte {
+	var buf bytes.Buffer
+	prefix := make([]byte, binary.MaxVarintLen64)
+	for _, f := range frames {
+		n := binary.PutUvarint(prefix, uint64(len(f)))
+		buf.Write(prefix[:n])
+		buf.WriteString(f)
+	}
+	return buf.Bytes()
+}
+
+func readAllFrames(t *testing.T, handle io.Reader, maxFrame int) ([]string, error) {
+	t.Helper()
+
+	r, err := NewVarintFrames(
+		func() (io.Reader, error) {
+			if handle == nil {
+				return nil, io.EOF
+			}
+			h := handle
+			handle = nil
+			return h, nil
+		},
+		func() {},
+		maxFrame,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []string
+	for {
+		msg, err := r.Read()
+		if err == types.ErrNotConnected {
+			if err = r.Connect(); err == types.ErrTypeClosed {
+				return frames, nil
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, string(msg.Get(0).Get()))
+		if err = r.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVarintFrames(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	data := varintFrames("foo", "", "bar\nbaz", long)
+
+	act, err := readAllFrames(t, iotest.OneByteReader(bytes.NewReader(data)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"foo", "", "bar\nbaz", long}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestVarintFramesMultipart(t *testing.T) {
+	data := varintFrames("foo", "bar", "", "baz", "")
+
+	r, err := NewVarintFrames(
+		func() (io.Reader, error) { return bytes.NewReader(data), nil },
+		func() {},
+		0,
+		OptLinesSetMultipart(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records of zero length end multipart messages.
+	for _, exp := range [][][]byte{
+		{[]byte("foo"), []byte("bar")},
+		{[]byte("baz")},
+	} {
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act := message.GetAllBytes(msg); !reflect.DeepEqual(act, exp) {
+			t.Errorf("Wrong result: %q != %q", act, exp)
+		}
+		if err = r.Acknowledge(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVarintFramesTooLong(t *testing.T) {
+	data := varintFrames("foo", "barbaz")
+
+	act, err := readAllFrames(t, bytes.NewReader(data), 4)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum") {
+		t.Errorf("Expected max frame error, received: %v", err)
+	}
+	if exp := []string{"foo"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+}
+
+func TestVarintFramesTruncated(t *testing.T) {
+	data := varintFrames("foo", "barbaz")
+
+	act, err := readAllFrames(t, bytes.NewReader(data[:len(data)-2]), 0)
+	if err == nil || !strings.Contains(err.Error(), errTruncatedFrame.Error()) {
+		t.Errorf("Expected truncated frame error, received: %v", err)
+	}
+	if exp := []string{"foo"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("Wrong result: %q != %q", act, exp)
+	}
+
+	if _, err = NewVarintFrames(nil, func() {}, -1); err == nil {
+		t.Error("Expected error from negative max frame")
+	}
+}
@@ -21,9 +21,6 @@
 package input
 
 import (
-	"io"
-	"os"
-
 	"github.com/Jeffail/benthos/v3/lib/input/reader"
 	"github.com/Jeffail/benthos/v3/lib/log"
 	"github.com/Jeffail/benthos/v3/lib/metrics"
@@ -41,7 +38,15 @@ messages are assumed single part and are line delimited. If the multipart option
 is set to true then lines are interpretted as message parts, and an empty line
 indicates the end of the message.
 
-If the delimiter field is left empty then line feed (\n) is used.`,
+A multipart message that is cut short by the end of the input is given the
+metadata field ` + "`multipart_incomplete`" + ` with the value ` + "`true`" + `.
+
+If the delimiter field is left empty then line feed (\n) is used.
+
+Each message part is given a ` + "`line_number`" + ` metadata field containing the
+position of its line within the stream, starting at 1, and the metadata fields
+` + "`start_offset` and `end_offset`" + ` containing the byte range of the line,
+including its delimiter, within the stream.`,
 	}
 }
 
@@ -72,22 +77,11 @@ func NewSTDIN(conf Config, mgr types.Manager, log log.Modular, stats metrics.Typ
 		delim = "\n"
 	}
 
-	stdin := os.Stdin
-	rdr, err := reader.NewLines(
-		func() (io.Reader, error) {
-			// Swap so this only works once since we don't want to read stdin
-			// multiple times.
-			if stdin == nil {
-				return nil, io.EOF
-			}
-			sendStdin := stdin
-			stdin = nil
-			return sendStdin, nil
-		},
-		func() {},
+	rdr, err := reader.NewStdinLines(
 		reader.OptLinesSetDelimiter(delim),
 		reader.OptLinesSetMaxBuffer(conf.STDIN.MaxBuffer),
 		reader.OptLinesSetMultipart(conf.STDIN.Multipart),
+		reader.OptLinesSetStats(stats),
 	)
 	if err != nil {
 		return nil, err
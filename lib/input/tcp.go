@@ -94,6 +94,7 @@ func NewTCP(conf Config, mgr types.Manager, log log.Modular, stats metrics.Type)
 		reader.OptLinesSetDelimiter(delim),
 		reader.OptLinesSetMaxBuffer(conf.TCP.MaxBuffer),
 		reader.OptLinesSetMultipart(conf.TCP.Multipart),
+		reader.OptLinesSetStats(stats),
 	)
 	if err != nil {
 		return nil, err